@@ -3,8 +3,10 @@ package bulwark
 import (
 	"context"
 	"errors"
+	"maps"
 	"math/rand"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/deixis/faults"
@@ -21,6 +23,9 @@ const (
 	// throttle will allow (approximately) through to the upstream, even if every
 	// request is failing.
 	MinRPS = 1
+
+	// windowBuckets is the number of buckets the time window is split into.
+	windowBuckets = 10
 )
 
 // AdaptiveThrottle is used in a client to throttle requests to a backend as it becomes unhealthy to
@@ -39,41 +44,264 @@ const (
 type AdaptiveThrottle struct {
 	m sync.Mutex
 
+	name string
+
 	k            float64
+	minRate      float64
+	d            time.Duration
 	minPerWindow float64
-
-	requests []windowedCounter
-	accepts  []windowedCounter
+	// priorityRatios and priorityMinRates override k and minRate for some
+	// priorities.
+	priorityRatios   map[Priority]float64
+	priorityMinRates map[Priority]float64
+
+	isRejectedError func(err error) bool
+	invalidPriority InvalidPriorityPolicy
+	priorityPolicy  PriorityPolicy // nil is StrictPriority
+	now             func() time.Time
+	random          func() float64
+	// windows is given to the priority policy, so it is not allocated for
+	// every request. t.m must be held to use it.
+	windows []PriorityWindow
+
+	dryRun         atomic.Bool
+	observers      []Observer
+	override       *Override
+	tenantFairness bool
+
+	requests      []windowedCounter
+	accepts       []windowedCounter
+	rejects       []windowedCounter
+	dryRunRejects []windowedCounter
+	// tenants counts the recent requests of each tenant by priority, when
+	// tenant fairness is enabled. The idle tenants are forgotten every
+	// bucket.
+	tenants       []map[string]*windowedCounter
+	tenantsPruned time.Time
+	// tenantShares caches the fair share of the tenants of each priority,
+	// which is computed at most once per bucket while the priority sheds
+	// requests. tenantDemands is reused to compute it.
+	tenantShares  []tenantShare
+	tenantDemands []fairDemand
+
+	// exchange shares the counts with peers, and remote holds the counts of
+	// the peers as of the last exchange. id identifies the throttle for the
+	// exchange.
+	id          uint64
+	exchange    StatsExchange
+	remote      []PriorityCounts
+	exchanged   time.Time
+	exchangeErr error
+
+	load       LoadSource
+	loadTarget float64
+
+	// slowStart is the duration of the ramp of each priority, which starts
+	// when the throttle is created and when a priority stops being shed.
+	slowStart time.Duration
+	ramp      RampFunc
+	ramps     []time.Time
+	shedding  []bool
+
+	// deadlineQuantile is the quantile of the latencies of each priority
+	// compared with the deadline of a request.
+	deadlineQuantile float64
+	// deadlines tells whether deadlineQuantile is positive, so the latencies
+	// are only recorded when they are used.
+	deadlines       atomic.Bool
+	latencies       []latencyTracker
+	deadlineRejects []windowedCounter
+
+	// queue holds the requests waiting to be admitted, if any.
+	queue *admissionQueue
+	// limiter limits the rate of the requests admitted, if any.
+	limiter *RateLimiter
 }
 
 // NewAdaptiveThrottle returns an AdaptiveThrottle.
 //
-// priorities is the number of priorities that the throttle will accept. Giving a priority outside
-// of `[0, priorities)` will panic.
+// priorities is the number of priorities that the throttle will accept. A priority outside of
+// `[0, priorities)` is resolved with the policy set by WithAdaptiveThrottleInvalidPriority, which
+// clamps it to the nearest valid priority by default.
 func NewAdaptiveThrottle(priorities int, options ...AdaptiveThrottleOption) *AdaptiveThrottle {
 	opts := adaptiveThrottleOptions{
 		d:       time.Minute,
 		k:       K,
 		minRate: MinRPS,
+		isRejectedError: func(err error) bool {
+			return IsRejectedError(err)
+		},
+		registry:        DefaultRegistry,
+		invalidPriority: ClampInvalidPriority,
+		now: func() time.Time {
+			return Now()
+		},
+		random: rand.Float64,
 	}
 	for _, option := range options {
 		option.f(&opts)
 	}
+	if opts.queue != nil {
+		opts.queue.now = opts.now
+	}
+	if !validWindow(opts.d) {
+		opts.d = time.Minute
+	}
+
+	now := opts.now()
+	newCounters := func() []windowedCounter {
+		counters := make([]windowedCounter, priorities)
+		for i := range counters {
+			counters[i] = newWindowedCounter(now, opts.d/windowBuckets, windowBuckets)
+		}
+
+		return counters
+	}
+
+	t := &AdaptiveThrottle{
+		name:          opts.name,
+		k:             opts.k,
+		minRate:       opts.minRate,
+		d:             opts.d,
+		requests:      newCounters(),
+		accepts:       newCounters(),
+		rejects:       newCounters(),
+		dryRunRejects: newCounters(),
+		minPerWindow:  opts.minRate * opts.d.Seconds(),
+
+		priorityRatios:   opts.priorityRatios,
+		priorityMinRates: opts.priorityMinRates,
+
+		isRejectedError: opts.isRejectedError,
+		invalidPriority: opts.invalidPriority,
+		priorityPolicy:  opts.priorityPolicy,
+		windows:         make([]PriorityWindow, priorities),
+		now:             opts.now,
+		random:          opts.random,
+		observers:       opts.observers,
+		tenantFairness:  opts.tenantFairness,
+		tenants:         newTenants(priorities),
+		tenantShares:    make([]tenantShare, priorities),
+		id:              throttleInstances.Add(1),
+		exchange:        opts.exchange,
+		load:            opts.load,
+		loadTarget:      opts.loadTarget,
+		slowStart:       opts.slowStart,
+		ramp:            opts.ramp,
+		ramps:           make([]time.Time, priorities),
+		shedding:        make([]bool, priorities),
+
+		deadlineQuantile: opts.deadlineQuantile,
+		latencies:        make([]latencyTracker, priorities),
+		deadlineRejects:  newCounters(),
+		queue:            opts.queue,
+		limiter:          opts.limiter,
+	}
+	t.dryRun.Store(opts.dryRun)
+	t.deadlines.Store(opts.deadlineQuantile > 0)
+	if opts.slowStart > 0 {
+		for i := range t.ramps {
+			t.ramps[i] = now
+		}
+	}
+	if opts.name != "" {
+		opts.registry.Register(opts.name, t)
+	}
 
-	now := Now()
-	requests := make([]windowedCounter, priorities)
-	accepts := make([]windowedCounter, priorities)
-	for i := range requests {
-		requests[i] = newWindowedCounter(now, opts.d/10, 10)
-		accepts[i] = newWindowedCounter(now, opts.d/10, 10)
+	return t
+}
+
+// Name returns the name given to the throttle with WithAdaptiveThrottleName.
+func (t *AdaptiveThrottle) Name() string {
+	return t.name
+}
+
+// Reconfigure applies the given options to a live throttle. Options that are
+// not given keep their current value.
+//
+// The accumulated request and accept counts are preserved. When the window
+// changes, the counts are redistributed into the new buckets according to
+// their age, and counts older than the new window are dropped.
+//
+// It is safe to call Reconfigure concurrently with Throttle.
+func (t *AdaptiveThrottle) Reconfigure(options ...AdaptiveThrottleOption) {
+	t.m.Lock()
+	defer t.m.Unlock()
+
+	opts := adaptiveThrottleOptions{
+		d:               t.d,
+		k:               t.k,
+		minRate:         t.minRate,
+		isRejectedError: t.isRejectedError,
+		// Copied, so per-priority options are added to the current ones
+		priorityRatios:   maps.Clone(t.priorityRatios),
+		priorityMinRates: maps.Clone(t.priorityMinRates),
+		priorityPolicy:   t.priorityPolicy,
+		dryRun:           t.dryRun.Load(),
+		tenantFairness:   t.tenantFairness,
+		slowStart:        t.slowStart,
+		ramp:             t.ramp,
+		deadlineQuantile: t.deadlineQuantile,
+	}
+	for _, option := range options {
+		option.f(&opts)
+	}
+	if !validWindow(opts.d) {
+		opts.d = t.d
 	}
 
-	return &AdaptiveThrottle{
-		k:            opts.k,
-		requests:     requests,
-		accepts:      accepts,
-		minPerWindow: opts.minRate * opts.d.Seconds(),
+	if opts.d != t.d {
+		now := t.now()
+		for _, counters := range t.counters() {
+			for i := range counters {
+				counters[i].resize(now, opts.d/windowBuckets)
+			}
+		}
+		for _, tenants := range t.tenants {
+			for _, c := range tenants {
+				c.resize(now, opts.d/windowBuckets)
+			}
+		}
+	}
+	if !opts.tenantFairness {
+		t.tenants = newTenants(len(t.requests))
 	}
+	if opts.d != t.d || !opts.tenantFairness {
+		t.tenantShares = make([]tenantShare, len(t.requests))
+	}
+
+	t.k = opts.k
+	t.minRate = opts.minRate
+	t.d = opts.d
+	t.minPerWindow = opts.minRate * opts.d.Seconds()
+	t.priorityRatios = opts.priorityRatios
+	t.priorityMinRates = opts.priorityMinRates
+	t.isRejectedError = opts.isRejectedError
+	t.priorityPolicy = opts.priorityPolicy
+	t.dryRun.Store(opts.dryRun)
+	t.tenantFairness = opts.tenantFairness
+	t.slowStart = opts.slowStart
+	t.ramp = opts.ramp
+	t.deadlineQuantile = opts.deadlineQuantile
+	t.deadlines.Store(opts.deadlineQuantile > 0)
+}
+
+// SetRatio changes the accept multiplier of a live throttle.
+// See WithAdaptiveThrottleRatio.
+func (t *AdaptiveThrottle) SetRatio(k float64) {
+	t.Reconfigure(WithAdaptiveThrottleRatio(k))
+}
+
+// SetMinimumRate changes the minimum rate of a live throttle.
+// See WithAdaptiveThrottleMinimumRate.
+func (t *AdaptiveThrottle) SetMinimumRate(x float64) {
+	t.Reconfigure(WithAdaptiveThrottleMinimumRate(x))
+}
+
+// SetWindow changes the time window of a live throttle.
+// See WithAdaptiveThrottleWindow.
+func (t *AdaptiveThrottle) SetWindow(d time.Duration) {
+	t.Reconfigure(WithAdaptiveThrottleWindow(d))
 }
 
 // Throttle sends a request to the backend when the adaptive throttle allows it.
@@ -90,52 +318,175 @@ func NewAdaptiveThrottle(priorities int, options ...AdaptiveThrottleOption) *Ada
 // `Throttle` may begin returning `ClientSideRejectionError` immediately
 // without invoking `throttledFn`. Lower-priority requests are preferred to be
 // rejected first.
+//
+// When the request is rejected locally or `throttledFn` returns an error, the
+// fallback functions are called in order with the error returned by the
+// previous one, until one of them returns nil.
 func (t *AdaptiveThrottle) Throttle(
 	ctx context.Context, defaultPriority Priority, fn throttledFn, fallbackFn ...fallbackFn,
 ) error {
-	priority := PriorityFromContext(ctx, defaultPriority)
-	now := Now()
+	priority, start, err := t.admit(ctx, defaultPriority)
+	if err != nil {
+		return fallback(ctx, err, true, fallbackFn)
+	}
+
+	err = t.record(ctx, priority, start, fn(t.withLoadReporter(ctx)))
+	if err != nil {
+		return fallback(ctx, err, false, fallbackFn)
+	}
+
+	return nil
+}
+
+// admit decides whether a request may be sent to the backend. It returns the
+// priority of the request, the time at which it was admitted, and the error to
+// return to the caller when the request is rejected locally.
+func (t *AdaptiveThrottle) admit(ctx context.Context, defaultPriority Priority) (Priority, time.Time, error) {
+	priority, err := t.priority(ctx, defaultPriority)
+	if err != nil {
+		return priority, time.Time{}, err
+	}
+
+	now := t.now()
+	if ok, err := t.overridden(ctx, priority, now); ok {
+		if err != nil {
+			return priority, now, err
+		}
+
+		// A forced admission overrides the health of the backend, not the
+		// deadline of the request or the rate limit.
+		return priority, now, t.admitLocally(ctx, priority, 0, now)
+	}
+
 	rejectionProbability := t.rejectionProbability(priority, now)
-	if rand.Float64() < rejectionProbability {
+	// The slow start follows the priority, as a tenant under its fair share
+	// is not throttled while the priority still sheds requests.
+	rampProbability := t.rampRejectionProbability(priority, rejectionProbability, now)
+	rejectionProbability = t.tenantRejectionProbability(priority, TenantFromContext(ctx), rejectionProbability, now)
+	r := t.random()
+	if r >= max(rejectionProbability, rampProbability) {
+		return priority, now, t.admitLocally(ctx, priority, rejectionProbability, now)
+	}
+	// Requests held back by the slow start do not indicate that the backend is
+	// overloaded, so they must not raise the rejection probability.
+	ramp := r >= rejectionProbability
+	rejectionProbability = max(rejectionProbability, rampProbability)
+
+	if t.dryRun.Load() {
+		// The request is sent anyway, so its outcome is recorded as usual.
+		t.m.Lock()
+		t.dryRunRejects[int(priority)].add(now, 1)
+		t.m.Unlock()
+		t.notify(ctx, priority, rejectionProbability, true, ClientSideRejectionError)
+
+		return priority, now, nil
+	}
+
+	err = ClientSideRejectionError
+	if t.queue != nil {
+		err = t.queue.wait(ctx, priority)
+		now = t.now()
+		if err == nil {
+			// The request takes the place of one that completed, but its
+			// deadline may have passed while it waited.
+			if err = t.admitLocally(ctx, priority, rejectionProbability, now); err != nil {
+				// Let the next request take its place
+				t.release()
+			}
+
+			return priority, now, err
+		}
+	}
+
+	t.m.Lock()
+	if !ramp {
 		// As Bulwark starts rejecting requests, requests will continue to exceed
 		// accepts. While it may seem counterintuitive, given that locally rejected
 		// requests aren't actually propagated, this is the preferred behavior. As the
 		// rate at which the application attempts requests to Bulwark grows
 		// (relative to the rate at which the backend accepts them), we want to
 		// increase the probability of dropping new requests.
-		t.reject(priority, now)
+		t.requests[int(priority)].add(now, 1)
+		t.countTenant(priority, TenantFromContext(ctx), now)
+	}
+	t.rejects[int(priority)].add(now, 1)
+	t.m.Unlock()
+	t.notify(ctx, priority, rejectionProbability, false, err)
+
+	return priority, now, err
+}
 
-		if len(fallbackFn) > 0 {
-			return fallbackFn[0](ctx, ClientSideRejectionError, true)
+// priority returns the priority of a request, resolved with the invalid
+// priority policy when it is out of range.
+func (t *AdaptiveThrottle) priority(ctx context.Context, defaultPriority Priority) (Priority, error) {
+	priority := PriorityFromContext(ctx, defaultPriority)
+	if priority < 0 || int(priority) >= len(t.requests) {
+		priority, err := t.invalidPriority(priority, len(t.requests))
+		if err != nil {
+			return priority, err
 		}
 
-		return ClientSideRejectionError
+		// A custom policy may return a priority out of range too
+		return clampPriority(priority, len(t.requests)), nil
 	}
 
-	err := fn(ctx)
+	return priority, nil
+}
 
-	now = Now()
-	switch {
-	case err == nil:
-		t.accept(priority, now)
-	case errors.Is(err, errRejected{}):
-		// Unwrap error to return the original error to the caller
-		err = err.(errRejected).inner
+// admitLocally applies the checks that do not depend on the health of the
+// backend to a request that the throttle admits, given its rejection
+// probability. It returns the error to return to the caller when one of them
+// rejects the request.
+func (t *AdaptiveThrottle) admitLocally(ctx context.Context, p Priority, probability float64, now time.Time) error {
+	if t.doomed(ctx, p, now) {
+		return t.rejectLocally(ctx, p, probability, now, DeadlineRejectionError, t.deadlineRejects)
+	}
+	if t.limiter != nil && !t.limiter.allow(p) {
+		return t.rejectLocally(ctx, p, probability, now, RateLimitRejectionError, nil)
+	}
 
-		fallthrough
-	case IsRejectedError(err):
-		t.reject(priority, now)
-	default:
-		t.accept(priority, now)
+	return nil
+}
+
+// rejectLocally rejects a request of the given priority, that the throttle
+// would otherwise admit, with the given error. The rejection is added to
+// counters, if any. In dry-run mode, the request is admitted.
+func (t *AdaptiveThrottle) rejectLocally(
+	ctx context.Context, p Priority, probability float64, now time.Time, err error, counters []windowedCounter,
+) error {
+	if t.dryRun.Load() {
+		t.m.Lock()
+		t.dryRunRejects[int(p)].add(now, 1)
+		t.m.Unlock()
+		t.notify(ctx, p, probability, true, err)
+
+		return nil
 	}
 
-	if err != nil && len(fallbackFn) > 0 {
-		return fallbackFn[0](ctx, err, false)
+	if counters != nil {
+		t.m.Lock()
+		counters[int(p)].add(now, 1)
+		t.m.Unlock()
 	}
+	t.notify(ctx, p, probability, false, err)
 
 	return err
 }
 
+// notify notifies the observers that a request was rejected locally, or would
+// have been in dry-run mode.
+func (t *AdaptiveThrottle) notify(ctx context.Context, p Priority, probability float64, dryRun bool, err error) {
+	for _, observer := range t.observers {
+		observer(ctx, Rejection{
+			Throttle:    t.name,
+			Priority:    p,
+			Probability: probability,
+			DryRun:      dryRun,
+			Err:         err,
+		})
+	}
+}
+
 // rejectionProbability returns the probability that a request of the given
 // priority will be rejected. The result is clamped to the range [0, 1].
 //
@@ -151,32 +502,136 @@ func (t *AdaptiveThrottle) Throttle(
 //   - k is the ratio of the measured success rate and the rate that the throttle will admit.
 //   - minPerWindow is the minimum number of requests per second that the adaptive throttle will allow
 //     (approximately) through to the upstream, even if every request is failing.
+//
+// How the requests of the other priorities are taken into account depends on
+// the priority policy. See PriorityPolicy.
 func (t *AdaptiveThrottle) rejectionProbability(p Priority, now time.Time) float64 {
 	t.m.Lock()
-	requests := float64(t.requests[int(p)].get(now))
-	accepts := float64(t.accepts[int(p)].get(now))
-	for i := 0; i < int(p); i++ {
-		// Also count non-accepted requests for every higher priority as
-		// non-accepted for this priority.
-		requests += float64(t.requests[i].get(now) - t.accepts[i].get(now))
+	policy, windows := t.priorityPolicy, t.windows
+	if policy == nil {
+		// The default policy only depends on the priority and the higher ones
+		policy, windows = StrictPriority, windows[:p+1]
+	}
+	for i := range windows {
+		windows[i] = PriorityWindow{
+			Requests:        float64(t.requests[i].get(now)),
+			Accepts:         float64(t.accepts[i].get(now)),
+			Ratio:           t.ratio(Priority(i)),
+			MinimumRequests: t.minimumRequests(Priority(i)),
+		}
+	}
+	for i, remote := range t.remoteCounts(now) {
+		if i < len(windows) {
+			windows[i].Requests += remote.Requests
+			windows[i].Accepts += remote.Accepts
+		}
 	}
+	probability := clamp(0, policy(p, windows), 1)
 	t.m.Unlock()
 
-	return clamp(0, (requests-t.k*accepts)/(requests+t.minPerWindow), 1)
+	return max(probability, t.loadRejectionProbability(p))
+}
+
+// ratio returns the accept multiplier of the given priority. t.m must be
+// held.
+func (t *AdaptiveThrottle) ratio(p Priority) float64 {
+	if k, ok := t.priorityRatios[p]; ok {
+		return k
+	}
+
+	return t.k
+}
+
+// minimumRate returns the minimum rate of the given priority. t.m must be
+// held.
+func (t *AdaptiveThrottle) minimumRate(p Priority) float64 {
+	if x, ok := t.priorityMinRates[p]; ok {
+		return x
+	}
+
+	return t.minRate
+}
+
+// minimumRequests returns the minimum number of requests of the given priority
+// sent within the window. t.m must be held.
+func (t *AdaptiveThrottle) minimumRequests(p Priority) float64 {
+	if x, ok := t.priorityMinRates[p]; ok {
+		return x * t.d.Seconds()
+	}
+
+	return t.minPerWindow
+}
+
+// record records the outcome of a request of the given priority that reached
+// the backend after being sent at start. It returns the error that should be
+// returned to the caller.
+func (t *AdaptiveThrottle) record(ctx context.Context, p Priority, start time.Time, err error) error {
+	now := t.now()
+	tenant := TenantFromContext(ctx)
+	switch {
+	case err == nil:
+		t.accept(p, tenant, now)
+		t.observeLatency(p, start, now)
+		t.release()
+	case t.isRejection(err):
+		t.reject(p, tenant, now)
+
+		// Unwrap error to return the original error to the caller
+		return unwrapRejected(err)
+	default:
+		// The request failed, but it was served by the backend
+		t.accept(p, tenant, now)
+		t.observeLatency(p, start, now)
+		t.release()
+	}
+
+	return err
+}
+
+// observeLatency records the latency of a request of the given priority sent
+// at start, when the deadlines of the requests are checked.
+func (t *AdaptiveThrottle) observeLatency(p Priority, start, now time.Time) {
+	if t.deadlines.Load() {
+		t.latencies[int(p)].observe(now, now.Sub(start))
+	}
+}
+
+// release admits a queued request, if any, once a request completed without
+// being rejected by the backend.
+func (t *AdaptiveThrottle) release() {
+	if t.queue != nil {
+		t.queue.release()
+	}
+}
+
+// isRejection returns whether an error returned by the backend indicates that
+// it is unhealthy.
+func (t *AdaptiveThrottle) isRejection(err error) bool {
+	if errors.Is(err, errRejected{}) {
+		return true
+	}
+
+	t.m.Lock()
+	isRejectedError := t.isRejectedError
+	t.m.Unlock()
+
+	return isRejectedError(err)
 }
 
 // accept records that a request of the given priority was accepted.
-func (t *AdaptiveThrottle) accept(p Priority, now time.Time) {
+func (t *AdaptiveThrottle) accept(p Priority, tenant string, now time.Time) {
 	t.m.Lock()
 	t.requests[int(p)].add(now, 1)
 	t.accepts[int(p)].add(now, 1)
+	t.countTenant(p, tenant, now)
 	t.m.Unlock()
 }
 
 // reject records that a request of the given priority was rejected.
-func (t *AdaptiveThrottle) reject(p Priority, now time.Time) {
+func (t *AdaptiveThrottle) reject(p Priority, tenant string, now time.Time) {
 	t.m.Lock()
 	t.requests[int(p)].add(now, 1)
+	t.countTenant(p, tenant, now)
 	t.m.Unlock()
 }
 
@@ -187,10 +642,30 @@ type AdaptiveThrottleOption struct {
 }
 
 type adaptiveThrottleOptions struct {
-	k               float64
-	minRate         float64
-	d               time.Duration
-	isErrorAccepted func(err error) bool
+	k                float64
+	minRate          float64
+	d                time.Duration
+	priorityRatios   map[Priority]float64
+	priorityMinRates map[Priority]float64
+	isErrorAccepted  func(err error) bool
+	isRejectedError  func(err error) bool
+	name             string
+	registry         *Registry
+	invalidPriority  InvalidPriorityPolicy
+	priorityPolicy   PriorityPolicy
+	now              func() time.Time
+	random           func() float64
+	dryRun           bool
+	observers        []Observer
+	tenantFairness   bool
+	exchange         StatsExchange
+	load             LoadSource
+	loadTarget       float64
+	slowStart        time.Duration
+	ramp             RampFunc
+	deadlineQuantile float64
+	queue            *admissionQueue
+	limiter          *RateLimiter
 }
 
 // WithAdaptiveThrottleRatio sets the ratio of the measured success rate and the rate that the throttle
@@ -215,14 +690,163 @@ func WithAdaptiveThrottleMinimumRate(x float64) AdaptiveThrottleOption {
 	}}
 }
 
+// WithAdaptiveThrottlePriorityRatio sets the ratio of the given priority, overriding the one set
+// with WithAdaptiveThrottleRatio. See WithAdaptiveThrottleRatio.
+func WithAdaptiveThrottlePriorityRatio(p Priority, k float64) AdaptiveThrottleOption {
+	return AdaptiveThrottleOption{func(opts *adaptiveThrottleOptions) {
+		if opts.priorityRatios == nil {
+			opts.priorityRatios = map[Priority]float64{}
+		}
+		opts.priorityRatios[p] = k
+	}}
+}
+
+// WithAdaptiveThrottlePriorityMinimumRate sets the minimum rate of the given priority, overriding
+// the one set with WithAdaptiveThrottleMinimumRate. It allows critical traffic to learn about the
+// recovery of the backend faster, while sheddable traffic barely probes it.
+// See WithAdaptiveThrottleMinimumRate.
+func WithAdaptiveThrottlePriorityMinimumRate(p Priority, x float64) AdaptiveThrottleOption {
+	return AdaptiveThrottleOption{func(opts *adaptiveThrottleOptions) {
+		if opts.priorityMinRates == nil {
+			opts.priorityMinRates = map[Priority]float64{}
+		}
+		opts.priorityMinRates[p] = x
+	}}
+}
+
 // WithAdaptiveThrottleWindow sets the time window over which the throttle remembers requests for use in
-// figuring out the success rate.
+// figuring out the success rate. A window that is not positive is ignored.
 func WithAdaptiveThrottleWindow(d time.Duration) AdaptiveThrottleOption {
 	return AdaptiveThrottleOption{func(opts *adaptiveThrottleOptions) {
 		opts.d = d
 	}}
 }
 
+// validWindow returns whether d can be split into the buckets of a window.
+func validWindow(d time.Duration) bool {
+	return d/windowBuckets > 0
+}
+
+// WithAdaptiveThrottleRejectedErrors sets the function that determines whether an error returned by
+// the throttled function indicates that the backend is unhealthy. It overrides the global
+// IsRejectedError for this throttle only. Errors wrapped with RejectedError are always considered
+// as rejections.
+func WithAdaptiveThrottleRejectedErrors(fn func(err error) bool) AdaptiveThrottleOption {
+	return AdaptiveThrottleOption{func(opts *adaptiveThrottleOptions) {
+		opts.isRejectedError = fn
+	}}
+}
+
+// WithAdaptiveThrottleName names the throttle and registers it in DefaultRegistry, or in the
+// registry given with WithAdaptiveThrottleRegistry. Named throttles can be listed and inspected by
+// metrics exporters and debug endpoints. Throttles with the same name are all registered, and
+// remain registered until they are removed with Registry.Unregister.
+//
+// This option is ignored by Reconfigure.
+func WithAdaptiveThrottleName(name string) AdaptiveThrottleOption {
+	return AdaptiveThrottleOption{func(opts *adaptiveThrottleOptions) {
+		opts.name = name
+	}}
+}
+
+// WithAdaptiveThrottleRegistry sets the registry in which a named throttle is registered. It
+// defaults to DefaultRegistry.
+//
+// This option is ignored by Reconfigure.
+func WithAdaptiveThrottleRegistry(r *Registry) AdaptiveThrottleOption {
+	return AdaptiveThrottleOption{func(opts *adaptiveThrottleOptions) {
+		opts.registry = r
+	}}
+}
+
+// WithAdaptiveThrottleInvalidPriority sets the policy applied to requests with a priority outside of
+// `[0, priorities)`. It defaults to ClampInvalidPriority.
+//
+// This option is ignored by Reconfigure.
+func WithAdaptiveThrottleInvalidPriority(policy InvalidPriorityPolicy) AdaptiveThrottleOption {
+	return AdaptiveThrottleOption{func(opts *adaptiveThrottleOptions) {
+		opts.invalidPriority = policy
+	}}
+}
+
+// WithAdaptiveThrottlePriorityPolicy sets how the priorities of the throttle interact. It defaults
+// to StrictPriority, which sheds lower priorities before any higher priority. WeightedPriority
+// guarantees each priority a share of the capacity, and IndependentPriority throttles each priority
+// on its own.
+func WithAdaptiveThrottlePriorityPolicy(policy PriorityPolicy) AdaptiveThrottleOption {
+	return AdaptiveThrottleOption{func(opts *adaptiveThrottleOptions) {
+		opts.priorityPolicy = policy
+	}}
+}
+
+// WithAdaptiveThrottleStatsExchange shares the statistics of the throttle with the throttles of
+// the same name of its peers through e. The counts of the peers are added to the local ones, so
+// the rejection probability reflects the outcomes of every peer calling the backend, rather than
+// the fraction of the traffic seen by this throttle. The counts are exchanged at most every 100ms.
+//
+// The throttle must have a name, set with WithAdaptiveThrottleName. Otherwise, or when e fails to
+// share the counts, the throttle only uses its own counts, and the error is reported in its
+// statistics.
+//
+// This option is ignored by Reconfigure.
+func WithAdaptiveThrottleStatsExchange(e StatsExchange) AdaptiveThrottleOption {
+	return AdaptiveThrottleOption{func(opts *adaptiveThrottleOptions) {
+		opts.exchange = e
+	}}
+}
+
+// WithAdaptiveThrottleHostStats shares the statistics of the throttle with the throttles of the
+// same name in the other processes of the host, through h. It is equivalent to
+// WithAdaptiveThrottleStatsExchange(h).
+//
+// This option is ignored by Reconfigure.
+func WithAdaptiveThrottleHostStats(h *HostStats) AdaptiveThrottleOption {
+	return WithAdaptiveThrottleStatsExchange(h)
+}
+
+// WithAdaptiveThrottleClock sets the function used by the throttle to get the current time. It
+// defaults to the global Now function. It allows running the throttle in virtual time, for example
+// in simulations.
+//
+// This option is ignored by Reconfigure.
+func WithAdaptiveThrottleClock(now func() time.Time) AdaptiveThrottleOption {
+	return AdaptiveThrottleOption{func(opts *adaptiveThrottleOptions) {
+		opts.now = now
+	}}
+}
+
+// WithAdaptiveThrottleRandom sets the function used by the throttle to draw random numbers in
+// `[0, 1)`. It defaults to rand.Float64. It allows reproducible simulations with a seeded source.
+// The function must be safe for concurrent use when the throttle is used concurrently.
+//
+// This option is ignored by Reconfigure.
+func WithAdaptiveThrottleRandom(random func() float64) AdaptiveThrottleOption {
+	return AdaptiveThrottleOption{func(opts *adaptiveThrottleOptions) {
+		opts.random = random
+	}}
+}
+
+// WithAdaptiveThrottleDryRun enables or disables the dry-run mode. In dry-run mode, the throttle
+// computes whether each request should be rejected, records it and notifies the observers, but
+// still sends the request to the backend. It allows measuring how much traffic the throttle would
+// shed before enforcing it.
+func WithAdaptiveThrottleDryRun(enabled bool) AdaptiveThrottleOption {
+	return AdaptiveThrottleOption{func(opts *adaptiveThrottleOptions) {
+		opts.dryRun = enabled
+	}}
+}
+
+// WithAdaptiveThrottleObserver adds an observer notified each time the throttle rejects a request
+// locally, or would reject it in dry-run mode. Observers are called synchronously, so they should
+// return quickly.
+//
+// This option is ignored by Reconfigure.
+func WithAdaptiveThrottleObserver(o Observer) AdaptiveThrottleOption {
+	return AdaptiveThrottleOption{func(opts *adaptiveThrottleOptions) {
+		opts.observers = append(opts.observers, o)
+	}}
+}
+
 // Deprecated: Wrap errors with RejectedError instead and use the global DefaultRejectedErrors.
 //
 // WithAcceptedErrors sets the function that determines whether an error should
@@ -234,6 +858,12 @@ func WithAcceptedErrors(fn func(err error) bool) AdaptiveThrottleOption {
 	}}
 }
 
+// Throttle is like AdaptiveThrottle.Throttle for functions that return a
+// value.
+//
+// When the request is rejected locally or `throttledFn` returns an error, the
+// fallback functions are called in order with the error returned by the
+// previous one, until one of them returns nil. See FallbackChain.
 func Throttle[T any](
 	ctx context.Context,
 	at *AdaptiveThrottle,
@@ -241,45 +871,15 @@ func Throttle[T any](
 	throttledFn throttledArgsFn[T],
 	fallbackFn ...fallbackArgsFn[T],
 ) (T, error) {
-	priority := PriorityFromContext(ctx, defaultPriority)
-	now := Now()
-	rejectionProbability := at.rejectionProbability(priority, now)
-	if rand.Float64() < rejectionProbability {
-		// As Bulwark starts rejecting requests, requests will continue to exceed
-		// accepts. While it may seem counterintuitive, given that locally rejected
-		// requests aren't actually propagated, this is the preferred behavior. As the
-		// rate at which the application attempts requests to Bulwark grows
-		// (relative to the rate at which the backend accepts them), we want to
-		// increase the probability of dropping new requests.
-		at.reject(priority, now)
-		var zero T
-
-		if len(fallbackFn) > 0 {
-			return fallbackFn[0](ctx, ClientSideRejectionError, true)
-		}
-
-		return zero, ClientSideRejectionError
-	}
-
-	t, err := throttledFn(ctx)
-
-	now = Now()
-	switch {
-	case err == nil:
-		at.accept(priority, now)
-	case errors.Is(err, errRejected{}):
-		// Unwrap error to return the original error to the caller
-		err = err.(errRejected).inner
-
-		fallthrough
-	case IsRejectedError(err):
-		at.reject(priority, now)
-	default:
-		at.accept(priority, now)
+	priority, start, err := at.admit(ctx, defaultPriority)
+	if err != nil {
+		return FallbackChain(fallbackFn...)(ctx, err, true)
 	}
 
+	t, err := throttledFn(at.withLoadReporter(ctx))
+	err = at.record(ctx, priority, start, err)
 	if err != nil && len(fallbackFn) > 0 {
-		return fallbackFn[0](ctx, err, false)
+		return FallbackChain(fallbackFn...)(ctx, err, false)
 	}
 
 	return t, err
@@ -299,39 +899,17 @@ func WithAdaptiveThrottle[T any](
 	priority Priority,
 	throttledFn func() (T, error),
 ) (T, error) {
-	now := Now()
-	rejectionProbability := at.rejectionProbability(priority, now)
-	if rand.Float64() < rejectionProbability {
-		// As Bulwark starts rejecting requests, requests will continue to exceed
-		// accepts. While it may seem counterintuitive, given that locally rejected
-		// requests aren't actually propagated, this is the preferred behavior. As the
-		// rate at which the application attempts requests to Bulwark grows
-		// (relative to the rate at which the backend accepts them), we want to
-		// increase the probability of dropping new requests.
-		at.reject(priority, now)
+	ctx := context.Background()
+	priority, start, err := at.admit(ctx, priority)
+	if err != nil {
 		var zero T
 
-		return zero, ClientSideRejectionError
+		return zero, err
 	}
 
 	t, err := throttledFn()
 
-	now = Now()
-	switch {
-	case err == nil:
-		at.accept(priority, now)
-	case errors.Is(err, errRejected{}):
-		at.reject(priority, now)
-
-		// Unwrap error to return the original error to the caller
-		err = err.(errRejected).inner
-	case IsRejectedError(err):
-		at.reject(priority, now)
-	default:
-		at.accept(priority, now)
-	}
-
-	return t, err
+	return t, at.record(ctx, priority, start, err)
 }
 
 // RejectedError wraps an error to indicate that the error should be considered
@@ -352,6 +930,17 @@ func (err errRejected) Is(target error) bool {
 	return ok
 }
 
+// unwrapRejected returns the error wrapped with RejectedError, even when err
+// wraps it, or err itself when it does not.
+func unwrapRejected(err error) error {
+	var rejected errRejected
+	if errors.As(err, &rejected) {
+		return rejected.inner
+	}
+
+	return err
+}
+
 // clamp clamps x to the range [min, max].
 func clamp(min, x, max float64) float64 {
 	if x < min {
@@ -364,6 +953,25 @@ func clamp(min, x, max float64) float64 {
 	return x
 }
 
+// Observer is notified of the requests rejected locally by a throttle.
+type Observer func(ctx context.Context, r Rejection)
+
+// Rejection describes a request rejected locally by a throttle.
+type Rejection struct {
+	// Throttle is the name of the throttle, if any.
+	Throttle string
+	// Priority is the priority of the request.
+	Priority Priority
+	// Probability is the rejection probability when the request was made.
+	Probability float64
+	// DryRun is true when the request was sent anyway because the throttle is
+	// in dry-run mode.
+	DryRun bool
+	// Err is the error returned, or that would have been returned, to the
+	// caller.
+	Err error
+}
+
 type (
 	throttledFn            func(ctx context.Context) error
 	fallbackFn             func(ctx context.Context, err error, local bool) error
@@ -4,6 +4,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"math"
 	"math/rand"
 	"strings"
 	"sync"
@@ -168,3 +169,540 @@ func TestInvalidFallback(t *testing.T) {
 		})
 	}
 }
+
+// TestReconfigure ensures a live throttle can be reconfigured without losing
+// the accumulated counts.
+func TestReconfigure(t *testing.T) {
+	now := time.Now()
+	Now = func() time.Time { return now }
+	defer func() { Now = time.Now }()
+
+	throttle := NewAdaptiveThrottle(StandardPriorities, WithAdaptiveThrottleWindow(10*time.Second))
+	for i := 0; i < 10; i++ {
+		throttle.reject(High, "", now)
+		now = now.Add(time.Second)
+	}
+	before := throttle.rejectionProbability(High, now)
+	if before == 0 {
+		t.Fatal("expected a non-zero rejection probability")
+	}
+
+	throttle.SetRatio(1)
+	throttle.SetMinimumRate(2)
+	if throttle.k != 1 {
+		t.Errorf("expected k to be 1, got %v", throttle.k)
+	}
+	if throttle.minPerWindow != 20 {
+		t.Errorf("expected minPerWindow to be 20, got %v", throttle.minPerWindow)
+	}
+
+	throttle.SetWindow(20 * time.Second)
+	if got := throttle.requests[High].get(now); got != 9 {
+		t.Errorf("expected 9 requests to be kept after growing the window, got %d", got)
+	}
+
+	throttle.SetWindow(4 * time.Second)
+	if got := throttle.requests[High].get(now); got != 3 {
+		t.Errorf("expected 3 requests to be kept after shrinking the window, got %d", got)
+	}
+
+	for _, d := range []time.Duration{0, -time.Second, time.Nanosecond} {
+		throttle.SetWindow(d)
+		if throttle.d != 4*time.Second {
+			t.Errorf("expected the window %s to be ignored, got %s", d, throttle.d)
+		}
+		if got := throttle.requests[High].get(now); got != 3 {
+			t.Errorf("expected the requests to be kept, got %d", got)
+		}
+		if n := NewAdaptiveThrottle(StandardPriorities, WithAdaptiveThrottleWindow(d)).d; n != time.Minute {
+			t.Errorf("expected the window %s to be replaced with the default one, got %s", d, n)
+		}
+	}
+}
+
+// TestInvalidPriority ensures priorities out of range are resolved with the
+// configured policy.
+func TestInvalidPriority(t *testing.T) {
+	ctx := context.Background()
+	ok := func(ctx context.Context) error { return nil }
+
+	t.Run("Clamp", func(t *testing.T) {
+		throttle := NewAdaptiveThrottle(StandardPriorities)
+		if err := throttle.Throttle(ctx, Priority(42), ok); err != nil {
+			t.Fatal(err)
+		}
+		if err := throttle.Throttle(ctx, Priority(-1), ok); err != nil {
+			t.Fatal(err)
+		}
+		if got := throttle.requests[Low].get(Now()); got != 1 {
+			t.Errorf("expected 1 low priority request, got %d", got)
+		}
+		if got := throttle.requests[High].get(Now()); got != 1 {
+			t.Errorf("expected 1 high priority request, got %d", got)
+		}
+	})
+
+	t.Run("Map", func(t *testing.T) {
+		throttle := NewAdaptiveThrottle(StandardPriorities, WithAdaptiveThrottleInvalidPriority(
+			MapInvalidPriority(func(p Priority) Priority { return Medium }),
+		))
+		if err := throttle.Throttle(ctx, Priority(42), ok); err != nil {
+			t.Fatal(err)
+		}
+		if got := throttle.requests[Medium].get(Now()); got != 1 {
+			t.Errorf("expected 1 medium priority request, got %d", got)
+		}
+	})
+
+	t.Run("Custom", func(t *testing.T) {
+		throttle := NewAdaptiveThrottle(StandardPriorities, WithAdaptiveThrottleInvalidPriority(
+			func(p Priority, priorities int) (Priority, error) { return p, nil },
+		))
+		if err := throttle.Throttle(ctx, Priority(42), ok); err != nil {
+			t.Fatal(err)
+		}
+		if got := throttle.requests[Low].get(Now()); got != 1 {
+			t.Errorf("expected the priority returned by the policy to be clamped, got %d low priority requests", got)
+		}
+	})
+
+	t.Run("Reject", func(t *testing.T) {
+		throttle := NewAdaptiveThrottle(StandardPriorities, WithAdaptiveThrottleInvalidPriority(RejectInvalidPriority))
+		local := false
+		err := throttle.Throttle(ctx, Priority(42), ok, func(ctx context.Context, err error, l bool) error {
+			local = l
+
+			return err
+		})
+		var invalid *InvalidPriorityError
+		if !errors.As(err, &invalid) || invalid.Priority != 42 {
+			t.Errorf("expected an InvalidPriorityError, got %v", err)
+		}
+		if !local {
+			t.Error("expected the fallback to be called with a local rejection")
+		}
+	})
+
+	t.Run("Panic", func(t *testing.T) {
+		throttle := NewAdaptiveThrottle(StandardPriorities, WithAdaptiveThrottleInvalidPriority(PanicOnInvalidPriority))
+		defer func() {
+			if recover() == nil {
+				t.Error("expected a panic")
+			}
+		}()
+		_, _ = WithAdaptiveThrottle(throttle, Priority(42), func() (struct{}, error) {
+			return struct{}{}, nil
+		})
+	})
+}
+
+// TestDryRun ensures requests are sent to the backend in dry-run mode, while
+// the rejections are recorded and observed.
+func TestDryRun(t *testing.T) {
+	ctx := context.Background()
+	var observed []Rejection
+	throttle := NewAdaptiveThrottle(
+		StandardPriorities,
+		WithAdaptiveThrottleRatio(1),
+		WithAdaptiveThrottleMinimumRate(0),
+		WithAdaptiveThrottleDryRun(true),
+		WithAdaptiveThrottleObserver(func(ctx context.Context, r Rejection) {
+			observed = append(observed, r)
+		}),
+	)
+
+	calls := 0
+	for i := 0; i < 100; i++ {
+		_ = throttle.Throttle(ctx, High, func(ctx context.Context) error {
+			calls++
+
+			return faults.Unavailable(0)
+		})
+	}
+
+	if calls != 100 {
+		t.Errorf("expected all calls to be sent in dry-run mode, got %d", calls)
+	}
+	stats := throttle.Stats().Priorities[High]
+	if stats.Rejections != 0 {
+		t.Errorf("expected no local rejections, got %d", stats.Rejections)
+	}
+	if stats.DryRunRejections == 0 || stats.DryRunRejections != len(observed) {
+		t.Errorf("expected %d dry-run rejections, got %d", len(observed), stats.DryRunRejections)
+	}
+	for _, r := range observed {
+		if !r.DryRun {
+			t.Errorf("expected observed rejection to be a dry run, got %+v", r)
+		}
+	}
+
+	throttle.Reconfigure(WithAdaptiveThrottleDryRun(false))
+	err := throttle.Throttle(ctx, High, func(ctx context.Context) error {
+		return nil
+	})
+	if !errors.Is(err, ClientSideRejectionError) {
+		t.Errorf("expected the request to be rejected once enforced, got %v", err)
+	}
+	if got := throttle.Stats().Priorities[High].Rejections; got != 1 {
+		t.Errorf("expected 1 local rejection, got %d", got)
+	}
+}
+
+// TestOverride ensures manual overrides take precedence over the rejection
+// probability until they expire.
+func TestOverride(t *testing.T) {
+	now := time.Now()
+	ctx := context.Background()
+	ok := func(ctx context.Context) error { return nil }
+	throttle := NewAdaptiveThrottle(
+		StandardPriorities,
+		WithAdaptiveThrottleMinimumRate(0),
+		WithAdaptiveThrottleClock(func() time.Time { return now }),
+	)
+
+	throttle.ForceReject(Medium, time.Minute)
+	for _, p := range []Priority{Medium, Low} {
+		if err := throttle.Throttle(ctx, p, ok); !errors.Is(err, ClientSideRejectionError) {
+			t.Errorf("expected priority %d to be rejected, got %v", p, err)
+		}
+	}
+	if err := throttle.Throttle(ctx, Important, ok); err != nil {
+		t.Errorf("expected priority %d to be admitted, got %v", Important, err)
+	}
+	if got := throttle.Stats().Priorities[Low]; got.Requests != 0 || got.Rejections != 1 {
+		t.Errorf("expected forced rejections to not count as requests, got %+v", got)
+	}
+
+	now = now.Add(time.Minute)
+	if _, ok := throttle.Override(); ok {
+		t.Error("expected the override to expire")
+	}
+	if err := throttle.Throttle(ctx, Low, ok); err != nil {
+		t.Errorf("expected the request to be admitted after expiry, got %v", err)
+	}
+
+	for i := 0; i < 10; i++ {
+		_ = throttle.Throttle(ctx, High, func(ctx context.Context) error {
+			return faults.Unavailable(0)
+		})
+	}
+	throttle.ForceAdmit(0)
+	for i := 0; i < 10; i++ {
+		if err := throttle.Throttle(ctx, High, ok); err != nil {
+			t.Fatalf("expected the request to be admitted, got %v", err)
+		}
+	}
+	throttle.ClearOverride()
+	if _, ok := throttle.Override(); ok {
+		t.Error("expected the override to be cleared")
+	}
+}
+
+// TestThrottleAllocs ensures a request sent with the default options does not
+// allocate.
+func TestThrottleAllocs(t *testing.T) {
+	throttle := NewAdaptiveThrottle(StandardPriorities, WithAdaptiveThrottleRegistry(NewRegistry()))
+	ctx := context.Background()
+	fn := func(ctx context.Context) error { return nil }
+	allocs := testing.AllocsPerRun(100, func() {
+		_ = throttle.Throttle(ctx, Medium, fn)
+	})
+	if allocs != 0 {
+		t.Errorf("expected no allocation, got %v", allocs)
+	}
+}
+
+// TestPriorityOptions ensures the ratio and minimum rate can be set per
+// priority.
+func TestPriorityOptions(t *testing.T) {
+	now := time.Now()
+	throttle := NewAdaptiveThrottle(
+		StandardPriorities,
+		WithAdaptiveThrottleWindow(10*time.Second),
+		WithAdaptiveThrottleClock(func() time.Time { return now }),
+		WithAdaptiveThrottlePriorityMinimumRate(High, 20),
+		WithAdaptiveThrottlePriorityRatio(Low, 1),
+	)
+	for i := 0; i < 10; i++ {
+		throttle.reject(High, "", now)
+		throttle.accept(Low, "", now)
+		throttle.reject(Low, "", now)
+	}
+
+	// 10 / (10 + 20 * 10)
+	if got := throttle.rejectionProbability(High, now); math.Abs(got-10.0/210) > 1e-9 {
+		t.Errorf("expected the minimum rate of High to be 20, got a probability of %v", got)
+	}
+	// (20 + 10 - 1 * 10) / (20 + 10 + 1 * 10), including the failures of High
+	if got := throttle.rejectionProbability(Low, now); math.Abs(got-0.5) > 1e-9 {
+		t.Errorf("expected the ratio of Low to be 1, got a probability of %v", got)
+	}
+
+	throttle.Reconfigure(WithAdaptiveThrottlePriorityRatio(Low, 2))
+	if got := throttle.Stats().Priorities; got[High].MinimumRate != 20 || got[Low].Ratio != 2 {
+		t.Errorf("expected per-priority options to be kept by Reconfigure, got %+v", got)
+	}
+}
+
+// TestWeightedPriority ensures weights that cannot be shared are rejected
+// when the policy is created.
+func TestWeightedPriority(t *testing.T) {
+	for _, w := range []float64{0, -1, math.NaN(), math.Inf(1)} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("expected a panic with a weight of %v", w)
+				}
+			}()
+			WeightedPriority(1, w)
+		}()
+	}
+
+	policy := WeightedPriority(3, 1)
+	windows := []PriorityWindow{{Requests: 100, Ratio: K}, {Requests: 100, Ratio: K}}
+	for p := range windows {
+		if got := policy(Priority(p), windows); math.IsNaN(got) || got < 0 || got > 1 {
+			t.Errorf("expected a probability of priority %d in [0, 1], got %v", p, got)
+		}
+	}
+}
+
+// TestSlowStart ensures the admitted fraction ramps up after the throttle is
+// created and after a priority stops being shed.
+func TestSlowStart(t *testing.T) {
+	now := time.Now()
+	throttle := NewAdaptiveThrottle(
+		StandardPriorities,
+		WithAdaptiveThrottleClock(func() time.Time { return now }),
+		WithAdaptiveThrottleRandom(func() float64 { return 0.5 }),
+		WithAdaptiveThrottleSlowStart(10*time.Second, LinearRamp(0.1)),
+	)
+	admitted := func() bool {
+		_, _, err := throttle.admit(context.Background(), High)
+
+		return err == nil
+	}
+
+	// 10% of the requests are admitted
+	if admitted() {
+		t.Error("expected the request to be rejected at the beginning of the slow start")
+	}
+	if got := throttle.requests[High].get(now); got != 0 {
+		t.Errorf("expected requests rejected by the slow start not to be counted, got %d", got)
+	}
+	// 55% of the requests are admitted
+	now = now.Add(5 * time.Second)
+	if !admitted() {
+		t.Error("expected the request to be admitted half way through the slow start")
+	}
+
+	// The backend becomes overloaded, then recovers.
+	now = now.Add(10 * time.Second)
+	for i := 0; i < 10; i++ {
+		throttle.reject(High, "", now)
+	}
+	if p := throttle.rejectionProbability(High, now); p == 0 {
+		t.Fatal("expected a non-zero rejection probability")
+	}
+	admitted()
+	for i := 0; i < 10; i++ {
+		throttle.accept(High, "", now)
+	}
+	if admitted() {
+		t.Error("expected the request to be rejected once the backend recovers")
+	}
+	now = now.Add(10 * time.Second)
+	if !admitted() {
+		t.Error("expected the request to be admitted at the end of the slow start")
+	}
+}
+
+// TestRamps ensures ramps that would reject every request are refused.
+func TestRamps(t *testing.T) {
+	for name, ramp := range map[string]func(float64) RampFunc{
+		"linear":      LinearRamp,
+		"exponential": ExponentialRamp,
+	} {
+		for _, start := range []float64{-1, 2, math.NaN()} {
+			func() {
+				defer func() {
+					if recover() == nil {
+						t.Errorf("%s: expected a panic with a start of %v", name, start)
+					}
+				}()
+				ramp(start)
+			}()
+		}
+	}
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Error("expected a panic with an exponential ramp starting at 0")
+			}
+		}()
+		ExponentialRamp(0)
+	}()
+
+	now := time.Now()
+	for name, option := range map[string]AdaptiveThrottleOption{
+		"duration": WithAdaptiveThrottleSlowStart(0, LinearRamp(0)),
+		"ramp":     WithAdaptiveThrottleSlowStart(time.Minute, nil),
+		"NaN": WithAdaptiveThrottleSlowStart(time.Minute, func(progress float64) float64 {
+			return math.NaN()
+		}),
+	} {
+		throttle := NewAdaptiveThrottle(
+			StandardPriorities,
+			WithAdaptiveThrottleRegistry(NewRegistry()),
+			WithAdaptiveThrottleClock(func() time.Time { return now }),
+			option,
+		)
+		if p := throttle.rampRejectionProbability(High, 0, now.Add(time.Second)); p != 0 {
+			t.Errorf("%s: expected the slow start not to reject requests, got %v", name, p)
+		}
+	}
+}
+
+func TestSlowStartTenants(t *testing.T) {
+	now := time.Now()
+	throttle := NewAdaptiveThrottle(
+		StandardPriorities,
+		WithAdaptiveThrottleClock(func() time.Time { return now }),
+		WithAdaptiveThrottleRandom(func() float64 { return 0.5 }),
+		WithAdaptiveThrottleSlowStart(10*time.Second, LinearRamp(0.1)),
+		WithAdaptiveThrottleTenantFairness(true),
+	)
+	admitted := func(tenant string) bool {
+		_, _, err := throttle.admit(WithTenant(context.Background(), tenant), High)
+
+		return err == nil
+	}
+
+	// The backend is overloaded by the noisy tenant.
+	now = now.Add(10 * time.Second)
+	for i := 0; i < 90; i++ {
+		throttle.reject(High, "noisy", now)
+	}
+	for i := 0; i < 10; i++ {
+		throttle.reject(High, "quiet", now)
+	}
+
+	// The quiet tenant is under its fair share, which must not end the
+	// shedding of the priority and start a ramp.
+	for i := 0; i < 3; i++ {
+		if !admitted("quiet") {
+			t.Error("expected the quiet tenant to be admitted")
+		}
+		if admitted("noisy") {
+			t.Error("expected the noisy tenant to be rejected")
+		}
+	}
+	if !throttle.shedding[High] || !throttle.ramps[High].IsZero() {
+		t.Error("expected the priority to still be shedding")
+	}
+}
+
+// TestTenantShare ensures the fair share of the tenants is not computed for
+// every request while a priority sheds requests.
+func TestTenantShare(t *testing.T) {
+	start := time.Now()
+	throttle := NewAdaptiveThrottle(
+		StandardPriorities,
+		WithAdaptiveThrottleRegistry(NewRegistry()),
+		WithAdaptiveThrottleTenantFairness(true),
+	)
+	for i := 0; i < 90; i++ {
+		throttle.reject(High, "noisy", start)
+	}
+	for i := 0; i < 10; i++ {
+		throttle.reject(High, "quiet", start)
+	}
+	computed := func() time.Time {
+		throttle.m.Lock()
+		defer throttle.m.Unlock()
+
+		return throttle.tenantShares[High].at
+	}
+
+	throttle.tenantRejectionProbability(High, "quiet", 0.5, start)
+	if at := computed(); !at.Equal(start) {
+		t.Fatalf("expected the fair share to be computed, got %s", at)
+	}
+	throttle.tenantRejectionProbability(High, "noisy", 0.5, start.Add(time.Second))
+	if at := computed(); !at.Equal(start) {
+		t.Errorf("expected the fair share to be cached within the bucket, got %s", at)
+	}
+	next := start.Add(6 * time.Second)
+	if p := throttle.tenantRejectionProbability(High, "noisy", 0.5, next); p == 0 {
+		t.Error("expected the noisy tenant to be throttled")
+	}
+	if at := computed(); !at.Equal(next) {
+		t.Errorf("expected the fair share to be computed once per bucket, got %s", at)
+	}
+}
+
+func TestAdmissionQueue(t *testing.T) {
+	q := newAdmissionQueue(2, time.Millisecond, time.Minute, nil)
+	timers := make(fakeTimers, 1)
+	q.after = timers.after
+	results := map[Priority]chan error{}
+	for _, p := range []Priority{Low, Medium, High} {
+		results[p] = make(chan error, 1)
+		go func() { results[p] <- q.wait(context.Background(), p) }()
+		<-timers // The request is queued
+	}
+
+	if err := <-results[Low]; !errors.Is(err, ClientSideRejectionError) {
+		t.Errorf("expected the lowest priority to make room for a higher one, got %v", err)
+	}
+	q.release()
+	if err := <-results[High]; err != nil {
+		t.Errorf("expected the highest priority to be released first, got %v", err)
+	}
+	q.release()
+	if err := <-results[Medium]; err != nil {
+		t.Errorf("expected the request to be released, got %v", err)
+	}
+}
+
+func TestTenantPruning(t *testing.T) {
+	now := time.Now()
+	throttle := NewAdaptiveThrottle(
+		StandardPriorities,
+		WithAdaptiveThrottleRegistry(NewRegistry()),
+		WithAdaptiveThrottleTenantFairness(true),
+		WithAdaptiveThrottleClock(func() time.Time { return now }),
+	)
+	send := func(tenant string) {
+		ctx := WithTenant(context.Background(), tenant)
+		_ = throttle.Throttle(ctx, High, func(ctx context.Context) error {
+			return nil
+		})
+	}
+	tracked := func() int {
+		throttle.m.Lock()
+		defer throttle.m.Unlock()
+
+		return len(throttle.tenants[High])
+	}
+
+	// The backend is healthy, so the priority never sheds requests.
+	for i := 0; i < 10; i++ {
+		send(fmt.Sprint("tenant-", i))
+	}
+	if n := tracked(); n != 10 {
+		t.Fatalf("expected 10 tenants, got %d", n)
+	}
+	now = now.Add(2 * time.Minute)
+	send("tenant-0")
+	if n := tracked(); n != 1 {
+		t.Errorf("expected the idle tenants to be forgotten, got %d tenants", n)
+	}
+
+	for i := 0; i < 2*maxTenants; i++ {
+		send(fmt.Sprint("tenant-", i))
+	}
+	if n := tracked(); n != maxTenants {
+		t.Errorf("expected %d tenants, got %d", maxTenants, n)
+	}
+}
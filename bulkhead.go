@@ -0,0 +1,184 @@
+package bulwark
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// DefaultBulkheadReserve is the default share of the slots of a Bulkhead
+// reserved for the requests of higher priorities.
+const DefaultBulkheadReserve = 0.1
+
+// Bulkhead limits the number of concurrent requests to a dependency, so a slow
+// dependency cannot exhaust the goroutines or the connections of the whole
+// service. Each dependency should have its own Bulkhead, and it can be
+// combined with an AdaptiveThrottle with Chain.
+//
+// A share of the slots is reserved for higher priorities: the requests of the
+// highest priority may use every slot, while the requests of lower priorities
+// leave a growing number of slots free. Requests are never queued: when no
+// slot is available, they are rejected immediately with a
+// *BulkheadRejectionError.
+//
+// It is safe to use a Bulkhead concurrently.
+type Bulkhead struct {
+	name       string
+	priorities int
+	limit      int
+	reserve    float64
+
+	m        sync.Mutex
+	inFlight int
+}
+
+// NewBulkhead returns a Bulkhead that allows up to limit concurrent requests.
+//
+// priorities is the number of priorities that the bulkhead will accept. A
+// priority outside of `[0, priorities)` is clamped to the nearest valid
+// priority.
+func NewBulkhead(priorities, limit int, options ...BulkheadOption) *Bulkhead {
+	opts := bulkheadOptions{
+		reserve: DefaultBulkheadReserve,
+	}
+	for _, option := range options {
+		option.f(&opts)
+	}
+
+	return &Bulkhead{
+		name:       opts.name,
+		priorities: priorities,
+		limit:      limit,
+		reserve:    opts.reserve,
+	}
+}
+
+// Additional options for the Bulkhead type.
+type BulkheadOption struct {
+	f func(*bulkheadOptions)
+}
+
+type bulkheadOptions struct {
+	name    string
+	reserve float64
+}
+
+// WithBulkheadName sets the name of the bulkhead, usually the name of the
+// dependency it protects. It is included in the rejection errors.
+func WithBulkheadName(name string) BulkheadOption {
+	return BulkheadOption{func(opts *bulkheadOptions) {
+		opts.name = name
+	}}
+}
+
+// WithBulkheadReserve sets the share, in `[0, 1]`, of the slots reserved for
+// higher priorities. The requests of the lowest priority leave this share of
+// the slots free, and the share left by the other priorities decreases
+// linearly up to the highest one. It defaults to DefaultBulkheadReserve.
+func WithBulkheadReserve(share float64) BulkheadOption {
+	return BulkheadOption{func(opts *bulkheadOptions) {
+		opts.reserve = share
+	}}
+}
+
+// Name returns the name given to the bulkhead with WithBulkheadName.
+func (b *Bulkhead) Name() string {
+	return b.name
+}
+
+// InFlight returns the number of requests currently holding a slot.
+func (b *Bulkhead) InFlight() int {
+	b.m.Lock()
+	defer b.m.Unlock()
+
+	return b.inFlight
+}
+
+// Throttle sends a request when a slot of the bulkhead is available for its
+// priority, and releases the slot once `fn` returns or panics.
+//
+// The default priority is used when the given `ctx` does not have a priority
+// set.
+//
+// When the request is rejected locally with a *BulkheadRejectionError or
+// `throttledFn` returns an error, the fallback functions are called in order
+// with the error returned by the previous one, until one of them returns nil.
+func (b *Bulkhead) Throttle(
+	ctx context.Context, defaultPriority Priority, fn throttledFn, fallbackFn ...fallbackFn,
+) error {
+	_, done, err := b.Admit(ctx, defaultPriority)
+	if err != nil {
+		return fallback(ctx, err, true, fallbackFn)
+	}
+	if _, err := send(ctx, done, func(ctx context.Context) (struct{}, error) {
+		return struct{}{}, fn(ctx)
+	}); err != nil {
+		return fallback(ctx, err, false, fallbackFn)
+	}
+
+	return nil
+}
+
+// Admit implements Limiter. The slot is released by the function returned.
+func (b *Bulkhead) Admit(ctx context.Context, defaultPriority Priority) (context.Context, func(err error) error, error) {
+	p := clampPriority(PriorityFromContext(ctx, defaultPriority), b.priorities)
+	if !b.acquire(p) {
+		return nil, nil, &BulkheadRejectionError{Bulkhead: b.name}
+	}
+
+	var once sync.Once
+	done := func(err error) error {
+		once.Do(b.release)
+
+		return err
+	}
+
+	return ctx, done, nil
+}
+
+// acquire takes a slot for a request of the given priority, if one is
+// available.
+func (b *Bulkhead) acquire(p Priority) bool {
+	b.m.Lock()
+	defer b.m.Unlock()
+
+	// The slots left free for higher priorities, capped so that every
+	// priority can be admitted when no request is in flight.
+	var reserved float64
+	if b.priorities > 1 {
+		reserved = float64(b.limit) * b.reserve * float64(p) / float64(b.priorities-1)
+	}
+	reserved = clamp(0, reserved, max(float64(b.limit-1), 0))
+	if float64(b.inFlight+1) > float64(b.limit)-reserved {
+		return false
+	}
+	b.inFlight++
+
+	return true
+}
+
+// release releases a slot.
+func (b *Bulkhead) release() {
+	b.m.Lock()
+	b.inFlight--
+	b.m.Unlock()
+}
+
+// BulkheadRejectionError is returned when a Bulkhead rejects a request because
+// all the slots available for its priority are taken. It wraps
+// ClientSideRejectionError, so errors.Is(err, ClientSideRejectionError) is
+// true.
+type BulkheadRejectionError struct {
+	// Bulkhead is the name of the bulkhead, if any.
+	Bulkhead string
+}
+
+func (err *BulkheadRejectionError) Error() string {
+	if err.Bulkhead == "" {
+		return "bulwark: bulkhead full"
+	}
+
+	return fmt.Sprintf("bulwark: bulkhead %q full", err.Bulkhead)
+}
+
+func (err *BulkheadRejectionError) Unwrap() error { return ClientSideRejectionError }
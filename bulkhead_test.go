@@ -0,0 +1,130 @@
+package bulwark_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/deixis/bulwark"
+)
+
+func TestBulkhead(t *testing.T) {
+	bulkhead := bulwark.NewBulkhead(
+		bulwark.StandardPriorities, 4,
+		bulwark.WithBulkheadName("backend"),
+		bulwark.WithBulkheadReserve(0.5),
+	)
+
+	// Hold slots until the end of the test
+	release := make(chan struct{})
+	started := make(chan error)
+	var wg sync.WaitGroup
+	hold := func(p bulwark.Priority) error {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := bulkhead.Throttle(context.Background(), p, func(ctx context.Context) error {
+				started <- nil
+				<-release
+
+				return nil
+			})
+			if err != nil {
+				started <- err
+			}
+		}()
+
+		return <-started
+	}
+
+	// Low requests leave half of the slots to higher priorities
+	for i := 0; i < 2; i++ {
+		if err := hold(bulwark.Low); err != nil {
+			t.Fatalf("expected the request to be admitted, got %v", err)
+		}
+	}
+	err := hold(bulwark.Low)
+	var rejection *bulwark.BulkheadRejectionError
+	if !errors.As(err, &rejection) || rejection.Bulkhead != "backend" {
+		t.Errorf("expected a bulkhead rejection, got %v", err)
+	}
+	if !errors.Is(err, bulwark.ClientSideRejectionError) {
+		t.Error("expected a bulkhead rejection to be a client-side rejection")
+	}
+	for i := 0; i < 2; i++ {
+		if err := hold(bulwark.High); err != nil {
+			t.Fatalf("expected the request to be admitted, got %v", err)
+		}
+	}
+	if n := bulkhead.InFlight(); n != 4 {
+		t.Errorf("expected 4 requests in flight, got %d", n)
+	}
+
+	// The fallback functions are called with the rejection
+	var local bool
+	err = bulkhead.Throttle(context.Background(), bulwark.High, func(ctx context.Context) error {
+		t.Error("expected the request not to be sent")
+
+		return nil
+	}, func(ctx context.Context, err error, l bool) error {
+		local = l
+
+		return nil
+	})
+	if err != nil || !local {
+		t.Errorf("expected the fallback to handle the local rejection, got %v", err)
+	}
+
+	close(release)
+	wg.Wait()
+	v, err := bulwark.Limit(context.Background(), bulwark.Chain(bulkhead), bulwark.Low, func(ctx context.Context) (int, error) {
+		return 1, nil
+	})
+	if v != 1 || err != nil {
+		t.Errorf("expected the slots to be released, got %v", err)
+	}
+}
+
+func TestBulkheadPanic(t *testing.T) {
+	bulkhead := bulwark.NewBulkhead(bulwark.StandardPriorities, 1)
+	chain := bulwark.Chain(bulkhead)
+
+	table := []struct {
+		name string
+		fn   func()
+	}{
+		{name: "Bulkhead", fn: func() {
+			_ = bulkhead.Throttle(context.Background(), bulwark.High, func(ctx context.Context) error {
+				panic("boom")
+			})
+		}},
+		{name: "Chain", fn: func() {
+			_ = chain.Throttle(context.Background(), bulwark.High, func(ctx context.Context) error {
+				panic("boom")
+			})
+		}},
+		{name: "Limit", fn: func() {
+			_, _ = bulwark.Limit(context.Background(), chain, bulwark.High, func(ctx context.Context) (int, error) {
+				panic("boom")
+			})
+		}},
+	}
+
+	for _, tt := range table {
+		t.Run(tt.name, func(t *testing.T) {
+			func() {
+				defer func() {
+					if r := recover(); r != "boom" {
+						t.Errorf("expected the panic to be propagated, got %v", r)
+					}
+				}()
+				tt.fn()
+			}()
+
+			if n := bulkhead.InFlight(); n != 0 {
+				t.Errorf("expected the slot to be released, got %d requests in flight", n)
+			}
+		})
+	}
+}
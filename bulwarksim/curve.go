@@ -0,0 +1,70 @@
+package bulwarksim
+
+import (
+	"sort"
+	"time"
+)
+
+// Curve returns a value, such as a rate of requests per second, at a given
+// time since the beginning of a scenario.
+type Curve func(elapsed time.Duration) float64
+
+// Point is a value at a given time since the beginning of a scenario.
+type Point struct {
+	At    time.Duration
+	Value float64
+}
+
+// Constant returns a curve that always returns x.
+func Constant(x float64) Curve {
+	return func(time.Duration) float64 { return x }
+}
+
+// Step returns a curve that holds the value of the most recent point. It
+// returns the value of the first point before it is reached.
+func Step(points ...Point) Curve {
+	points = sortPoints(points)
+
+	return func(elapsed time.Duration) float64 {
+		if len(points) == 0 {
+			return 0
+		}
+		i := sort.Search(len(points), func(i int) bool { return points[i].At > elapsed })
+		if i == 0 {
+			return points[0].Value
+		}
+
+		return points[i-1].Value
+	}
+}
+
+// Linear returns a curve that interpolates linearly between points. It holds
+// the value of the first and last points outside of their range.
+func Linear(points ...Point) Curve {
+	points = sortPoints(points)
+
+	return func(elapsed time.Duration) float64 {
+		if len(points) == 0 {
+			return 0
+		}
+		i := sort.Search(len(points), func(i int) bool { return points[i].At > elapsed })
+		if i == 0 {
+			return points[0].Value
+		}
+		if i == len(points) {
+			return points[len(points)-1].Value
+		}
+
+		from, to := points[i-1], points[i]
+		progress := float64(elapsed-from.At) / float64(to.At-from.At)
+
+		return from.Value + (to.Value-from.Value)*progress
+	}
+}
+
+func sortPoints(points []Point) []Point {
+	points = append([]Point(nil), points...)
+	sort.SliceStable(points, func(i, j int) bool { return points[i].At < points[j].At })
+
+	return points
+}
@@ -0,0 +1,320 @@
+// Package bulwarksim runs scenarios through a bulwark.AdaptiveThrottle in
+// virtual time.
+//
+// A scenario describes the demand of each priority, the capacity of the backend
+// and the errors it returns over time. Running it reports how much traffic the
+// throttle sent and rejected, and how long it took to recover once the backend
+// was healthy again. Because time is simulated, minutes of traffic run in
+// milliseconds, which makes it practical to compare the ratio, minimum rate and
+// window of a throttle before changing them in production.
+package bulwarksim
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"text/tabwriter"
+	"time"
+
+	"github.com/deixis/bulwark"
+	"github.com/deixis/faults"
+)
+
+const (
+	// DefaultTick is the default resolution of a simulation.
+	DefaultTick = 10 * time.Millisecond
+	// DefaultSampleInterval is the default interval at which samples are
+	// recorded.
+	DefaultSampleInterval = time.Second
+	// RecoveryThreshold is the ratio of local rejections under which the
+	// throttle is considered recovered.
+	RecoveryThreshold = 0.01
+
+	// backendBurst is the duration of traffic at full capacity that the
+	// simulated backend can absorb at once.
+	backendBurst = 100 * time.Millisecond
+)
+
+// Scenario describes the traffic sent to a backend through an adaptive throttle.
+type Scenario struct {
+	// Duration is the simulated duration of the scenario.
+	Duration time.Duration
+	// Tick is the resolution of the simulation. Requests are spread evenly
+	// within a tick. It defaults to DefaultTick.
+	Tick time.Duration
+	// SampleInterval is the interval at which samples are recorded. When the
+	// tick does not divide it, a sample ends with the first tick after the
+	// interval. It defaults to DefaultSampleInterval.
+	SampleInterval time.Duration
+
+	// Demand is the number of requests per second of each priority. The
+	// throttle is created with len(Demand) priorities.
+	Demand []Curve
+	// Capacity is the number of requests per second that the backend can
+	// serve. Requests over capacity fail with faults.Unavailable.
+	Capacity Curve
+	// ErrorRate is the ratio of requests served by the backend that fail with
+	// an error that indicates the backend is unhealthy. It is optional.
+	ErrorRate Curve
+
+	// Options are given to bulwark.NewAdaptiveThrottle. The clock and the
+	// random source of the throttle are set by the simulation.
+	Options []bulwark.AdaptiveThrottleOption
+	// Seed seeds the random source of the simulation, so runs are
+	// reproducible.
+	Seed int64
+}
+
+// Result is the outcome of a scenario.
+type Result struct {
+	// Duration is the simulated duration of the scenario.
+	Duration time.Duration
+	// Priorities holds the totals of each priority over the whole scenario.
+	Priorities []Counts
+	// Samples holds the totals of each interval of the scenario.
+	Samples []Sample
+	// Recovered is true when the throttle stopped rejecting requests after
+	// the last time the backend was overloaded, or when it was never
+	// overloaded.
+	Recovered bool
+	// TimeToRecovery is the time between the end of the last overload and the
+	// first sample in which less than RecoveryThreshold of the requests were
+	// rejected locally.
+	TimeToRecovery time.Duration
+}
+
+// Sample holds the totals of an interval of a scenario.
+type Sample struct {
+	// At is the end of the interval since the beginning of the scenario.
+	At time.Duration
+	// Capacity is the capacity of the backend at the end of the interval.
+	Capacity float64
+	// Priorities holds the totals of each priority over the interval.
+	Priorities []Counts
+}
+
+// Counts are the number of requests of a priority.
+type Counts struct {
+	// Requests is the number of requests made by the application.
+	Requests int
+	// Sent is the number of requests sent to the backend.
+	Sent int
+	// Rejected is the number of requests rejected locally by the throttle.
+	Rejected int
+	// Failed is the number of requests sent to the backend that failed.
+	Failed int
+}
+
+// RejectRatio returns the ratio of requests rejected locally.
+func (c Counts) RejectRatio() float64 {
+	if c.Requests == 0 {
+		return 0
+	}
+
+	return float64(c.Rejected) / float64(c.Requests)
+}
+
+func (c *Counts) add(x Counts) {
+	c.Requests += x.Requests
+	c.Sent += x.Sent
+	c.Rejected += x.Rejected
+	c.Failed += x.Failed
+}
+
+// Run runs the scenario and returns its result.
+func Run(s Scenario) Result {
+	if s.Tick <= 0 {
+		s.Tick = DefaultTick
+	}
+	if s.SampleInterval <= 0 {
+		s.SampleInterval = DefaultSampleInterval
+	}
+	if s.Capacity == nil {
+		s.Capacity = Constant(math.Inf(1))
+	}
+	if s.ErrorRate == nil {
+		s.ErrorRate = Constant(0)
+	}
+
+	rng := rand.New(rand.NewSource(s.Seed))
+	start := time.Unix(0, 0)
+	now := start
+	throttle := bulwark.NewAdaptiveThrottle(len(s.Demand), append(
+		append([]bulwark.AdaptiveThrottleOption(nil), s.Options...),
+		bulwark.WithAdaptiveThrottleClock(func() time.Time { return now }),
+		bulwark.WithAdaptiveThrottleRandom(rng.Float64),
+	)...)
+
+	result := Result{
+		Duration:   s.Duration,
+		Priorities: make([]Counts, len(s.Demand)),
+	}
+	sample := Sample{Priorities: make([]Counts, len(s.Demand))}
+	tokens := 0.0
+	overloaded := false
+	overloadEnd := time.Duration(-1)
+	nextSample := s.SampleInterval
+	ctx := context.Background()
+
+	for elapsed := time.Duration(0); elapsed < s.Duration; elapsed += s.Tick {
+		capacity := s.Capacity(elapsed)
+		errorRate := s.ErrorRate(elapsed)
+
+		// Backend: a token bucket refilled at the current capacity, which can
+		// absorb short bursts of requests.
+		burst := math.Max(1, capacity*backendBurst.Seconds())
+		tokens = math.Min(burst, tokens+capacity*s.Tick.Seconds())
+
+		// Application: requests arrive randomly at the demanded rate.
+		var arrivals []bulwark.Priority
+		demand := 0.0
+		for i, curve := range s.Demand {
+			rate := curve(elapsed)
+			demand += rate
+			for n := poisson(rng, rate*s.Tick.Seconds()); n > 0; n-- {
+				arrivals = append(arrivals, bulwark.Priority(i))
+			}
+		}
+		rng.Shuffle(len(arrivals), func(i, j int) { arrivals[i], arrivals[j] = arrivals[j], arrivals[i] })
+
+		switch {
+		case demand > capacity:
+			overloaded = true
+		case overloaded:
+			overloaded = false
+			overloadEnd = elapsed
+		}
+
+		for i, p := range arrivals {
+			now = start.Add(elapsed + s.Tick*time.Duration(i)/time.Duration(len(arrivals)))
+			counts := &sample.Priorities[p]
+			counts.Requests++
+
+			var sent, failed bool
+			_ = throttle.Throttle(ctx, p, func(ctx context.Context) error {
+				sent = true
+				if tokens < 1 {
+					failed = true
+
+					return faults.Unavailable(0)
+				}
+				tokens--
+				if rng.Float64() < errorRate {
+					failed = true
+
+					return bulwark.RejectedError(faults.Unavailable(0))
+				}
+
+				return nil
+			})
+			switch {
+			case !sent:
+				counts.Rejected++
+			case failed:
+				counts.Sent++
+				counts.Failed++
+			default:
+				counts.Sent++
+			}
+		}
+
+		// A sample is recorded at the end of the first tick reaching the
+		// interval, as the tick may not divide it.
+		next := elapsed + s.Tick
+		if next >= nextSample || next >= s.Duration {
+			for nextSample <= next {
+				nextSample += s.SampleInterval
+			}
+			sample.At = next
+			sample.Capacity = capacity
+			result.Samples = append(result.Samples, sample)
+			for i, counts := range sample.Priorities {
+				result.Priorities[i].add(counts)
+			}
+			sample = Sample{Priorities: make([]Counts, len(s.Demand))}
+		}
+	}
+
+	result.Recovered, result.TimeToRecovery = recovery(result.Samples, overloaded, overloadEnd)
+
+	return result
+}
+
+// poisson draws the number of events in an interval in which lambda events are
+// expected.
+func poisson(rng *rand.Rand, lambda float64) int {
+	if lambda <= 0 {
+		return 0
+	}
+	if lambda > 30 {
+		// Normal approximation
+		return int(math.Max(0, math.Round(lambda+math.Sqrt(lambda)*rng.NormFloat64())))
+	}
+
+	l := math.Exp(-lambda)
+	n := 0
+	for p := rng.Float64(); p > l; p *= rng.Float64() {
+		n++
+	}
+
+	return n
+}
+
+// recovery returns whether the throttle recovered after the end of the last
+// overload, and how long it took.
+func recovery(samples []Sample, overloaded bool, overloadEnd time.Duration) (bool, time.Duration) {
+	if overloaded {
+		return false, 0
+	}
+	if overloadEnd < 0 {
+		return true, 0
+	}
+
+	for _, sample := range samples {
+		if sample.At <= overloadEnd {
+			continue
+		}
+
+		var total Counts
+		for _, counts := range sample.Priorities {
+			total.add(counts)
+		}
+		if total.RejectRatio() < RecoveryThreshold {
+			return true, sample.At - overloadEnd
+		}
+	}
+
+	return false, 0
+}
+
+// WriteTable writes the request, send and reject rates of each priority as a
+// table.
+func (r Result) WriteTable(w io.Writer) error {
+	seconds := r.Duration.Seconds()
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprint(tw, "priority\trequest rate\tsend rate\treject %\tfail %\n")
+	for i, counts := range r.Priorities {
+		failRatio := 0.0
+		if counts.Sent > 0 {
+			failRatio = float64(counts.Failed) / float64(counts.Sent)
+		}
+		fmt.Fprintf(
+			tw,
+			"%d\t%.2f/sec\t%.2f/sec\t%.2f%%\t%.2f%%\n",
+			i,
+			float64(counts.Requests)/seconds,
+			float64(counts.Sent)/seconds,
+			counts.RejectRatio()*100,
+			failRatio*100,
+		)
+	}
+	if r.Recovered {
+		fmt.Fprintf(tw, "recovered in %s\n", r.TimeToRecovery)
+	} else {
+		fmt.Fprint(tw, "not recovered\n")
+	}
+
+	return tw.Flush()
+}
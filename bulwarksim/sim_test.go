@@ -0,0 +1,169 @@
+package bulwarksim_test
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/deixis/bulwark"
+	"github.com/deixis/bulwark/bulwarksim"
+)
+
+func TestRun(t *testing.T) {
+	result := bulwarksim.Run(bulwarksim.Scenario{
+		Duration: 2 * time.Minute,
+		Demand: []bulwarksim.Curve{
+			bulwarksim.Constant(5),
+			bulwarksim.Constant(10),
+			bulwarksim.Constant(20),
+		},
+		// The backend loses half of its capacity for a minute.
+		Capacity: bulwarksim.Step(
+			bulwarksim.Point{At: 0, Value: 40},
+			bulwarksim.Point{At: 30 * time.Second, Value: 20},
+			bulwarksim.Point{At: 90 * time.Second, Value: 40},
+		),
+		Options: []bulwark.AdaptiveThrottleOption{
+			bulwark.WithAdaptiveThrottleWindow(3 * time.Second),
+		},
+	})
+
+	var sb strings.Builder
+	if err := result.WriteTable(&sb); err != nil {
+		t.Fatal(err)
+	}
+	t.Log("\n" + sb.String())
+
+	if len(result.Samples) != 120 {
+		t.Errorf("expected 120 samples, got %d", len(result.Samples))
+	}
+	high, low := result.Priorities[0], result.Priorities[2]
+	if high.Requests < 540 || high.Requests > 660 {
+		t.Errorf("expected about 600 high priority requests, got %d", high.Requests)
+	}
+	if high.RejectRatio() >= low.RejectRatio() {
+		t.Errorf(
+			"expected high priority to be rejected less than low priority, got %.2f and %.2f",
+			high.RejectRatio(), low.RejectRatio(),
+		)
+	}
+	if !result.Recovered {
+		t.Error("expected the throttle to recover")
+	}
+	if result.TimeToRecovery > 30*time.Second {
+		t.Errorf("expected to recover within 30s, got %s", result.TimeToRecovery)
+	}
+}
+
+func TestRunSampleInterval(t *testing.T) {
+	result := bulwarksim.Run(bulwarksim.Scenario{
+		Duration:       10 * time.Second,
+		Demand:         []bulwarksim.Curve{bulwarksim.Constant(10)},
+		Tick:           30 * time.Millisecond,
+		SampleInterval: time.Second,
+	})
+
+	// The tick does not divide the interval, so each sample ends with the
+	// first tick after it.
+	if len(result.Samples) != 10 {
+		t.Fatalf("expected 10 samples, got %d", len(result.Samples))
+	}
+	if at := result.Samples[0].At; at != 1020*time.Millisecond {
+		t.Errorf("expected the first sample at 1.02s, got %s", at)
+	}
+	var requests int
+	for _, sample := range result.Samples {
+		requests += sample.Priorities[0].Requests
+	}
+	if requests != result.Priorities[0].Requests {
+		t.Errorf("expected the samples to hold every request, got %d of %d", requests, result.Priorities[0].Requests)
+	}
+}
+
+func TestRunReproducible(t *testing.T) {
+	s := bulwarksim.Scenario{
+		Duration: 30 * time.Second,
+		Demand:   []bulwarksim.Curve{bulwarksim.Constant(10), bulwarksim.Constant(10)},
+		Capacity: bulwarksim.Constant(5),
+		Seed:     42,
+	}
+	a, b := bulwarksim.Run(s), bulwarksim.Run(s)
+	for i := range a.Priorities {
+		if a.Priorities[i] != b.Priorities[i] {
+			t.Errorf("expected identical runs, got %+v and %+v", a.Priorities[i], b.Priorities[i])
+		}
+	}
+}
+
+func TestCurves(t *testing.T) {
+	step := bulwarksim.Step(
+		bulwarksim.Point{At: time.Second, Value: 1},
+		bulwarksim.Point{At: 3 * time.Second, Value: 3},
+	)
+	linear := bulwarksim.Linear(
+		bulwarksim.Point{At: time.Second, Value: 1},
+		bulwarksim.Point{At: 3 * time.Second, Value: 3},
+	)
+	table := []struct {
+		at     time.Duration
+		step   float64
+		linear float64
+	}{
+		{at: 0, step: 1, linear: 1},
+		{at: 2 * time.Second, step: 1, linear: 2},
+		{at: 3 * time.Second, step: 3, linear: 3},
+		{at: time.Minute, step: 3, linear: 3},
+	}
+	for _, tt := range table {
+		if got := step(tt.at); got != tt.step {
+			t.Errorf("Step(%s) = %v; want %v", tt.at, got, tt.step)
+		}
+		if got := linear(tt.at); got != tt.linear {
+			t.Errorf("Linear(%s) = %v; want %v", tt.at, got, tt.linear)
+		}
+	}
+}
+
+func TestPriorityPolicies(t *testing.T) {
+	// The high priority alone exceeds the capacity of the backend.
+	scenario := func(policy bulwark.PriorityPolicy) bulwarksim.Result {
+		return bulwarksim.Run(bulwarksim.Scenario{
+			Duration: 2 * time.Minute,
+			Demand: []bulwarksim.Curve{
+				bulwarksim.Constant(30),
+				bulwarksim.Constant(10),
+			},
+			Capacity: bulwarksim.Constant(10),
+			Options: []bulwark.AdaptiveThrottleOption{
+				bulwark.WithAdaptiveThrottleWindow(3 * time.Second),
+				bulwark.WithAdaptiveThrottlePriorityPolicy(policy),
+			},
+			Seed: 1,
+		})
+	}
+
+	strict := scenario(bulwark.StrictPriority)
+	weighted := scenario(bulwark.WeightedPriority(3, 1))
+	independent := scenario(bulwark.IndependentPriority)
+	for name, result := range map[string]bulwarksim.Result{
+		"strict": strict, "weighted": weighted, "independent": independent,
+	} {
+		var sb strings.Builder
+		if err := result.WriteTable(&sb); err != nil {
+			t.Fatal(err)
+		}
+		t.Logf("%s\n%s", name, sb.String())
+	}
+
+	if r := strict.Priorities[1].RejectRatio(); r < 0.9 {
+		t.Errorf("expected the low priority to be starved with strict priorities, got %.2f", r)
+	}
+	// The low priority is guaranteed a quarter of the capacity.
+	sent := weighted.Priorities[0].Sent + weighted.Priorities[1].Sent
+	if share := float64(weighted.Priorities[1].Sent) / float64(sent); share < 0.15 || share > 0.35 {
+		t.Errorf("expected the low priority to get about 25%% of the capacity with weighted priorities, got %.2f", share)
+	}
+	if r := independent.Priorities[1].RejectRatio(); r > 0.8 {
+		t.Errorf("expected the low priority not to be starved with independent priorities, got %.2f", r)
+	}
+}
@@ -0,0 +1,141 @@
+// Command bulwark-sim runs a scenario through an adaptive throttle in virtual
+// time, and writes the traffic over time as CSV and as an SVG chart.
+//
+// Usage:
+//
+//	bulwark-sim [-csv out.csv] [-svg out.svg] scenario.json
+//
+// A scenario describes the demand of each priority, the capacity of the backend
+// and the configuration of the throttle:
+//
+//	{
+//	  "duration": "2m",
+//	  "demand": [
+//	    {"points": [{"at": "0s", "value": 5}]},
+//	    {"points": [{"at": "0s", "value": 20}]}
+//	  ],
+//	  "capacity": {"points": [{"at": "0s", "value": 40}, {"at": "30s", "value": 10}]},
+//	  "throttle": {"ratio": 2, "window": "10s"}
+//	}
+//
+// The per-priority request, send and reject rates are printed on the standard
+// output.
+package main
+
+import (
+	"encoding/csv"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+
+	"github.com/deixis/bulwark/bulwarksim"
+)
+
+func main() {
+	csvPath := flag.String("csv", "", "write the samples as CSV to this file")
+	svgPath := flag.String("svg", "", "write a chart of the samples as SVG to this file")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: %s [flags] scenario.json\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	if err := run(flag.Arg(0), *csvPath, *svgPath); err != nil {
+		fmt.Fprintln(os.Stderr, "bulwark-sim:", err)
+		os.Exit(1)
+	}
+}
+
+func run(scenarioPath, csvPath, svgPath string) error {
+	f, err := os.Open(scenarioPath)
+	if err != nil {
+		return err
+	}
+	s, err := loadScenario(f)
+	f.Close()
+	if err != nil {
+		return fmt.Errorf("%s: %w", scenarioPath, err)
+	}
+
+	result := bulwarksim.Run(s)
+	if err := result.WriteTable(os.Stdout); err != nil {
+		return err
+	}
+
+	if csvPath != "" {
+		if err := writeFile(csvPath, func(w io.Writer) error { return writeCSV(w, result) }); err != nil {
+			return err
+		}
+	}
+	if svgPath != "" {
+		if err := writeFile(svgPath, func(w io.Writer) error { return writeSVG(w, result) }); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func writeFile(path string, write func(w io.Writer) error) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := write(f); err != nil {
+		f.Close()
+
+		return fmt.Errorf("%s: %w", path, err)
+	}
+
+	return f.Close()
+}
+
+// writeCSV writes one row per sample, with the rates per second of each
+// priority.
+func writeCSV(w io.Writer, result bulwarksim.Result) error {
+	cw := csv.NewWriter(w)
+
+	header := []string{"time", "capacity"}
+	for i := range result.Priorities {
+		p := strconv.Itoa(i)
+		header = append(header, "p"+p+"_requests", "p"+p+"_sent", "p"+p+"_rejected", "p"+p+"_failed")
+	}
+	if err := cw.Write(header); err != nil {
+		return err
+	}
+
+	formatRate := func(n int, seconds float64) string {
+		return strconv.FormatFloat(float64(n)/seconds, 'f', 2, 64)
+	}
+	var previous float64
+	for _, sample := range result.Samples {
+		at := sample.At.Seconds()
+		seconds := at - previous
+		previous = at
+
+		row := []string{
+			strconv.FormatFloat(at, 'f', -1, 64),
+			strconv.FormatFloat(sample.Capacity, 'f', 2, 64),
+		}
+		for _, counts := range sample.Priorities {
+			row = append(row,
+				formatRate(counts.Requests, seconds),
+				formatRate(counts.Sent, seconds),
+				formatRate(counts.Rejected, seconds),
+				formatRate(counts.Failed, seconds),
+			)
+		}
+		if err := cw.Write(row); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+
+	return cw.Error()
+}
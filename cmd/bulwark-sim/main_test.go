@@ -0,0 +1,144 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/deixis/bulwark/bulwarksim"
+)
+
+var update = flag.Bool("update", false, "update the golden files")
+
+func TestLoadScenario(t *testing.T) {
+	table := []struct {
+		name     string
+		scenario string
+		err      string
+	}{
+		{
+			name:     "Valid",
+			scenario: `{"duration": "1m", "demand": [{"points": [{"at": "0s", "value": 10}]}]}`,
+		},
+		{
+			name:     "Duration",
+			scenario: `{"demand": [{"points": [{"at": "0s", "value": 10}]}]}`,
+			err:      "duration must be positive",
+		},
+		{
+			name:     "Demand",
+			scenario: `{"duration": "1m"}`,
+			err:      "demand must have at least one priority",
+		},
+		{
+			name:     "UnknownField",
+			scenario: `{"duration": "1m", "demand": [{"points": [{"at": "0s", "value": 10}]}], "foo": 1}`,
+			err:      "unknown field",
+		},
+		{
+			name:     "NegativeValue",
+			scenario: `{"duration": "1m", "demand": [{"points": [{"at": "0s", "value": -1}]}]}`,
+			err:      "demand of priority 0: value must not be negative",
+		},
+		{
+			name:     "Interpolation",
+			scenario: `{"duration": "1m", "demand": [{"interpolation": "cubic", "points": [{"at": "0s", "value": 1}]}]}`,
+			err:      `unknown interpolation "cubic"`,
+		},
+		{
+			name:     "EmptyCurve",
+			scenario: `{"duration": "1m", "demand": [{"points": [{"at": "0s", "value": 1}]}], "capacity": {}}`,
+			err:      "capacity: curve must have at least one point",
+		},
+		{
+			// The number of priorities is given by the demand, not by the
+			// default of the throttle configuration.
+			name: "PriorityRatios",
+			scenario: `{"duration": "1m", "demand": [{"points": [{"at": "0s", "value": 1}]}, {"points": [{"at": "0s", "value": 1}]}],
+				"throttle": {"priority_ratios": {"3": 2}}}`,
+			err: "ratio of priority 3: priority must be in [0, 2)",
+		},
+	}
+
+	for _, tt := range table {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := loadScenario(strings.NewReader(tt.scenario))
+			switch {
+			case tt.err == "" && err != nil:
+				t.Errorf("expected no error, got %v", err)
+			case tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)):
+				t.Errorf("expected an error containing %q, got %v", tt.err, err)
+			}
+		})
+	}
+}
+
+// TestReadme runs the scenario of the README, and compares the CSV and the SVG
+// chart with the golden files. Run with -update to regenerate them.
+func TestReadme(t *testing.T) {
+	f, err := os.Open(filepath.Join("testdata", "readme.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	s, err := loadScenario(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result := bulwarksim.Run(s)
+
+	table := []struct {
+		golden string
+		write  func(w *bytes.Buffer) error
+	}{
+		{golden: "readme.csv", write: func(w *bytes.Buffer) error { return writeCSV(w, result) }},
+		{golden: "readme.svg", write: func(w *bytes.Buffer) error { return writeSVG(w, result) }},
+	}
+
+	for _, tt := range table {
+		t.Run(tt.golden, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := tt.write(&buf); err != nil {
+				t.Fatal(err)
+			}
+
+			path := filepath.Join("testdata", tt.golden)
+			if *update {
+				if err := os.WriteFile(path, buf.Bytes(), 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			want, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(buf.Bytes(), want) {
+				t.Errorf("%s differs from the golden file, run the test with -update to regenerate it", tt.golden)
+			}
+		})
+	}
+}
+
+func TestNiceCeil(t *testing.T) {
+	table := []struct {
+		x, want float64
+	}{
+		{x: -1, want: 1},
+		{x: 0, want: 1},
+		{x: 0.3, want: 0.5},
+		{x: 1, want: 1},
+		{x: 1.5, want: 2},
+		{x: 3, want: 5},
+		{x: 7, want: 10},
+		{x: 120, want: 200},
+	}
+
+	for _, tt := range table {
+		if got := niceCeil(tt.x); got != tt.want {
+			t.Errorf("niceCeil(%v): expected %v, got %v", tt.x, tt.want, got)
+		}
+	}
+}
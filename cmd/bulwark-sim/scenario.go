@@ -0,0 +1,118 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/deixis/bulwark"
+	"github.com/deixis/bulwark/bulwarksim"
+)
+
+// scenarioFile is the JSON representation of a bulwarksim.Scenario.
+type scenarioFile struct {
+	Duration       bulwark.Duration `json:"duration"`
+	Tick           bulwark.Duration `json:"tick,omitempty"`
+	SampleInterval bulwark.Duration `json:"sample_interval,omitempty"`
+	Seed           int64            `json:"seed,omitempty"`
+
+	// Demand is the number of requests per second of each priority.
+	Demand []curveFile `json:"demand"`
+	// Capacity is the number of requests per second served by the backend.
+	Capacity *curveFile `json:"capacity,omitempty"`
+	// ErrorRate is the ratio of requests served by the backend that fail.
+	ErrorRate *curveFile `json:"error_rate,omitempty"`
+
+	// Throttle configures the adaptive throttle. Its number of priorities is
+	// ignored, as it is given by Demand, and the priorities of the options are
+	// validated against it.
+	Throttle bulwark.ThrottleConfig `json:"throttle"`
+}
+
+// curveFile is the JSON representation of a bulwarksim.Curve.
+type curveFile struct {
+	// Interpolation is either "step" (default) or "linear".
+	Interpolation string      `json:"interpolation,omitempty"`
+	Points        []pointFile `json:"points"`
+}
+
+type pointFile struct {
+	At    bulwark.Duration `json:"at"`
+	Value float64          `json:"value"`
+}
+
+// loadScenario decodes and validates a scenario file.
+func loadScenario(r io.Reader) (bulwarksim.Scenario, error) {
+	var f scenarioFile
+	dec := json.NewDecoder(r)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&f); err != nil {
+		return bulwarksim.Scenario{}, fmt.Errorf("decode scenario: %w", err)
+	}
+
+	if f.Duration <= 0 {
+		return bulwarksim.Scenario{}, fmt.Errorf("duration must be positive, got %s", f.Duration)
+	}
+	if len(f.Demand) == 0 {
+		return bulwarksim.Scenario{}, fmt.Errorf("demand must have at least one priority")
+	}
+	f.Throttle.Priorities = len(f.Demand)
+	if err := f.Throttle.Validate(); err != nil {
+		return bulwarksim.Scenario{}, fmt.Errorf("throttle: %w", err)
+	}
+
+	s := bulwarksim.Scenario{
+		Duration:       time.Duration(f.Duration),
+		Tick:           time.Duration(f.Tick),
+		SampleInterval: time.Duration(f.SampleInterval),
+		Seed:           f.Seed,
+		Options:        f.Throttle.Options(),
+	}
+	for i, c := range f.Demand {
+		curve, err := c.curve()
+		if err != nil {
+			return bulwarksim.Scenario{}, fmt.Errorf("demand of priority %d: %w", i, err)
+		}
+		s.Demand = append(s.Demand, curve)
+	}
+	if f.Capacity != nil {
+		curve, err := f.Capacity.curve()
+		if err != nil {
+			return bulwarksim.Scenario{}, fmt.Errorf("capacity: %w", err)
+		}
+		s.Capacity = curve
+	}
+	if f.ErrorRate != nil {
+		curve, err := f.ErrorRate.curve()
+		if err != nil {
+			return bulwarksim.Scenario{}, fmt.Errorf("error rate: %w", err)
+		}
+		s.ErrorRate = curve
+	}
+
+	return s, nil
+}
+
+func (c curveFile) curve() (bulwarksim.Curve, error) {
+	if len(c.Points) == 0 {
+		return nil, fmt.Errorf("curve must have at least one point")
+	}
+
+	points := make([]bulwarksim.Point, len(c.Points))
+	for i, p := range c.Points {
+		if p.Value < 0 {
+			return nil, fmt.Errorf("value must not be negative, got %v", p.Value)
+		}
+		points[i] = bulwarksim.Point{At: time.Duration(p.At), Value: p.Value}
+	}
+
+	switch c.Interpolation {
+	case "", "step":
+		return bulwarksim.Step(points...), nil
+	case "linear":
+		return bulwarksim.Linear(points...), nil
+	default:
+		return nil, fmt.Errorf("unknown interpolation %q", c.Interpolation)
+	}
+}
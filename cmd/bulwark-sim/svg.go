@@ -0,0 +1,133 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/deixis/bulwark/bulwarksim"
+)
+
+const (
+	chartWidth  = 800
+	chartHeight = 400
+	chartMargin = 50
+	chartTicks  = 5
+)
+
+// series is a line of the chart.
+type series struct {
+	name   string
+	color  string
+	dashed bool
+	values []float64
+}
+
+// writeSVG writes a line chart of the demand, the traffic sent to the backend,
+// the traffic it served, the local rejections and the capacity of the backend.
+func writeSVG(w io.Writer, result bulwarksim.Result) error {
+	demand := series{name: "demand", color: "#1f77b4"}
+	sent := series{name: "sent", color: "#ff7f0e"}
+	served := series{name: "served", color: "#2ca02c"}
+	rejected := series{name: "rejected", color: "#d62728"}
+	capacity := series{name: "capacity", color: "#7f7f7f", dashed: true}
+
+	var previous time.Duration
+	for _, sample := range result.Samples {
+		seconds := (sample.At - previous).Seconds()
+		previous = sample.At
+
+		var total bulwarksim.Counts
+		for _, counts := range sample.Priorities {
+			total.Requests += counts.Requests
+			total.Sent += counts.Sent
+			total.Rejected += counts.Rejected
+			total.Failed += counts.Failed
+		}
+		demand.values = append(demand.values, float64(total.Requests)/seconds)
+		sent.values = append(sent.values, float64(total.Sent)/seconds)
+		served.values = append(served.values, float64(total.Sent-total.Failed)/seconds)
+		rejected.values = append(rejected.values, float64(total.Rejected)/seconds)
+		capacity.values = append(capacity.values, sample.Capacity)
+	}
+	all := []series{demand, sent, served, rejected, capacity}
+
+	yMax := 0.0
+	for _, s := range all {
+		for _, v := range s.values {
+			if !math.IsInf(v, 0) {
+				yMax = math.Max(yMax, v)
+			}
+		}
+	}
+	yMax = niceCeil(yMax)
+	xMax := result.Duration.Seconds()
+
+	plotWidth := float64(chartWidth - 2*chartMargin)
+	plotHeight := float64(chartHeight - 2*chartMargin)
+	x := func(seconds float64) float64 { return chartMargin + seconds/xMax*plotWidth }
+	y := func(v float64) float64 {
+		return chartMargin + plotHeight - math.Min(v, yMax)/yMax*plotHeight
+	}
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" font-family="sans-serif" font-size="12">`+"\n", chartWidth, chartHeight)
+	fmt.Fprintf(&sb, `<rect width="%d" height="%d" fill="white"/>`+"\n", chartWidth, chartHeight)
+
+	// Axes and grid
+	for i := 0; i <= chartTicks; i++ {
+		v := yMax * float64(i) / chartTicks
+		fmt.Fprintf(&sb, `<line x1="%d" y1="%.1f" x2="%d" y2="%.1f" stroke="#eee"/>`+"\n", chartMargin, y(v), chartWidth-chartMargin, y(v))
+		fmt.Fprintf(&sb, `<text x="%d" y="%.1f" text-anchor="end" dominant-baseline="middle">%g</text>`+"\n", chartMargin-5, y(v), v)
+
+		t := xMax * float64(i) / chartTicks
+		fmt.Fprintf(&sb, `<text x="%.1f" y="%d" text-anchor="middle">%s</text>`+"\n", x(t), chartHeight-chartMargin+15, time.Duration(t*float64(time.Second)).Round(time.Second))
+	}
+	fmt.Fprintf(&sb, `<line x1="%d" y1="%d" x2="%d" y2="%d" stroke="black"/>`+"\n", chartMargin, chartHeight-chartMargin, chartWidth-chartMargin, chartHeight-chartMargin)
+	fmt.Fprintf(&sb, `<line x1="%d" y1="%d" x2="%d" y2="%d" stroke="black"/>`+"\n", chartMargin, chartMargin, chartMargin, chartHeight-chartMargin)
+	fmt.Fprintf(&sb, `<text x="%d" y="%d">requests/sec</text>`+"\n", chartMargin, chartMargin-10)
+
+	// Lines and legend
+	for i, s := range all {
+		var points []string
+		for j, v := range s.values {
+			if math.IsInf(v, 0) {
+				continue
+			}
+			points = append(points, fmt.Sprintf("%.1f,%.1f", x(result.Samples[j].At.Seconds()), y(v)))
+		}
+		dash := ""
+		if s.dashed {
+			dash = ` stroke-dasharray="6,4"`
+		}
+		fmt.Fprintf(&sb, `<polyline fill="none" stroke="%s" stroke-width="2"%s points="%s"/>`+"\n", s.color, dash, strings.Join(points, " "))
+
+		lx := chartWidth - chartMargin - 90
+		ly := chartMargin + 10 + i*18
+		fmt.Fprintf(&sb, `<line x1="%d" y1="%d" x2="%d" y2="%d" stroke="%s" stroke-width="2"%s/>`+"\n", lx, ly, lx+20, ly, s.color, dash)
+		fmt.Fprintf(&sb, `<text x="%d" y="%d" dominant-baseline="middle">%s</text>`+"\n", lx+25, ly, s.name)
+	}
+	sb.WriteString("</svg>\n")
+
+	_, err := io.WriteString(w, sb.String())
+
+	return err
+}
+
+// niceCeil rounds x up to 1, 2 or 5 times a power of ten.
+func niceCeil(x float64) float64 {
+	if x <= 0 {
+		return 1
+	}
+
+	p := math.Pow(10, math.Floor(math.Log10(x)))
+	for _, m := range []float64{1, 2, 5, 10} {
+		if x <= m*p {
+			return m * p
+		}
+	}
+
+	return 10 * p
+}
@@ -0,0 +1,310 @@
+package bulwark
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// minBucketWidth is the smallest bucket width accepted in a configuration.
+// Narrower buckets are dominated by the clock resolution and the overhead of
+// rotating them.
+const minBucketWidth = time.Millisecond
+
+// Config describes a set of named adaptive throttles. It can be decoded from
+// JSON with LoadConfig, or from YAML with any decoder that honours the `yaml`
+// struct tags.
+//
+//	{
+//	  "throttles": {
+//	    "payments": {"priorities": 4, "ratio": 1.5, "minimum_rate": 0.5, "window": "30s"},
+//	    "search": {"classifier": "all"}
+//	  }
+//	}
+type Config struct {
+	Throttles map[string]ThrottleConfig `json:"throttles" yaml:"throttles"`
+}
+
+// ThrottleConfig describes a single adaptive throttle. Zero values fall back to
+// the defaults used by NewAdaptiveThrottle.
+type ThrottleConfig struct {
+	// Priorities is the number of priorities that the throttle will accept.
+	// It defaults to StandardPriorities.
+	Priorities int `json:"priorities,omitempty" yaml:"priorities,omitempty"`
+	// Ratio is the accept multiplier. See WithAdaptiveThrottleRatio.
+	Ratio float64 `json:"ratio,omitempty" yaml:"ratio,omitempty"`
+	// MinimumRate is the minimum number of requests per second sent to the
+	// backend. See WithAdaptiveThrottleMinimumRate.
+	MinimumRate *float64 `json:"minimum_rate,omitempty" yaml:"minimum_rate,omitempty"`
+	// PriorityRatios overrides Ratio for some priorities, by priority.
+	// See WithAdaptiveThrottlePriorityRatio.
+	PriorityRatios map[Priority]float64 `json:"priority_ratios,omitempty" yaml:"priority_ratios,omitempty"`
+	// PriorityMinimumRates overrides MinimumRate for some priorities, by
+	// priority. See WithAdaptiveThrottlePriorityMinimumRate.
+	PriorityMinimumRates map[Priority]float64 `json:"priority_minimum_rates,omitempty" yaml:"priority_minimum_rates,omitempty"`
+	// Window is the time window over which requests are remembered.
+	// See WithAdaptiveThrottleWindow.
+	Window Duration `json:"window,omitempty" yaml:"window,omitempty"`
+	// Classifier is the name of an entry of ErrorClassifiers used to decide
+	// whether an error indicates that the backend is unhealthy.
+	// It defaults to "global".
+	Classifier string `json:"classifier,omitempty" yaml:"classifier,omitempty"`
+	// InvalidPriority is the policy applied to priorities out of range, one of
+	// "clamp", "reject" or "panic". It defaults to "clamp".
+	// See WithAdaptiveThrottleInvalidPriority.
+	InvalidPriority string `json:"invalid_priority,omitempty" yaml:"invalid_priority,omitempty"`
+	// PriorityPolicy is how priorities interact, one of "strict",
+	// "independent" or "weighted". It defaults to "strict".
+	// See WithAdaptiveThrottlePriorityPolicy.
+	PriorityPolicy string `json:"priority_policy,omitempty" yaml:"priority_policy,omitempty"`
+	// PriorityWeights are the weights of the priorities, in priority order,
+	// when PriorityPolicy is "weighted". See WeightedPriority.
+	PriorityWeights []float64 `json:"priority_weights,omitempty" yaml:"priority_weights,omitempty"`
+	// DryRun computes and records rejections without enforcing them.
+	// See WithAdaptiveThrottleDryRun.
+	DryRun bool `json:"dry_run,omitempty" yaml:"dry_run,omitempty"`
+	// TenantFairness shares the capacity of each priority fairly between
+	// tenants. See WithAdaptiveThrottleTenantFairness.
+	TenantFairness bool `json:"tenant_fairness,omitempty" yaml:"tenant_fairness,omitempty"`
+	// DeadlineQuantile is the quantile, in (0, 1), of the recent latencies
+	// that the deadline of a request must exceed for it to be sent.
+	// See WithAdaptiveThrottleDeadline.
+	DeadlineQuantile float64 `json:"deadline_quantile,omitempty" yaml:"deadline_quantile,omitempty"`
+}
+
+// ErrorClassifiers are the presets that can be referenced by
+// ThrottleConfig.Classifier. Additional presets can be registered before
+// loading a configuration.
+var ErrorClassifiers = map[string]func(err error) bool{
+	// global uses the global IsRejectedError function.
+	"global": func(err error) bool { return IsRejectedError(err) },
+	// faults only considers unavailable and resource exhausted errors from the
+	// `faults` package.
+	"faults": func(err error) bool { return DefaultRejectedError(err) },
+	// explicit only considers errors wrapped with RejectedError.
+	"explicit": func(err error) bool { return false },
+	// all considers every error, except cancellations, as a rejection.
+	"all": func(err error) bool { return !errors.Is(err, context.Canceled) },
+}
+
+// invalidPriorityPolicies are the policies that can be referenced by
+// ThrottleConfig.InvalidPriority.
+var invalidPriorityPolicies = map[string]InvalidPriorityPolicy{
+	"clamp":  ClampInvalidPriority,
+	"reject": RejectInvalidPriority,
+	"panic":  PanicOnInvalidPriority,
+}
+
+// priorityPolicies are the policies that can be referenced by
+// ThrottleConfig.PriorityPolicy. The "weighted" policy is built from
+// ThrottleConfig.PriorityWeights.
+var priorityPolicies = map[string]PriorityPolicy{
+	"strict":      StrictPriority,
+	"independent": IndependentPriority,
+	"weighted":    nil,
+}
+
+// LoadConfig decodes a JSON configuration from r and validates it.
+func LoadConfig(r io.Reader) (Config, error) {
+	var c Config
+	dec := json.NewDecoder(r)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&c); err != nil {
+		return Config{}, fmt.Errorf("bulwark: decode config: %w", err)
+	}
+	if err := c.Validate(); err != nil {
+		return Config{}, err
+	}
+
+	return c, nil
+}
+
+// Validate returns an error describing every invalid value in the
+// configuration.
+func (c Config) Validate() error {
+	var errs []error
+	for name, tc := range c.Throttles {
+		if err := tc.Validate(); err != nil {
+			errs = append(errs, fmt.Errorf("bulwark: throttle %q: %w", name, err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// NewRegistry validates the configuration and returns a registry containing
+// one throttle per entry.
+func (c Config) NewRegistry() (*Registry, error) {
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+
+	r := NewRegistry()
+	for name, tc := range c.Throttles {
+		tc.NewAdaptiveThrottle(WithAdaptiveThrottleName(name), WithAdaptiveThrottleRegistry(r))
+	}
+
+	return r, nil
+}
+
+// Validate returns an error describing every invalid value in the throttle
+// configuration.
+func (c ThrottleConfig) Validate() error {
+	var errs []error
+	if c.Priorities < 0 || c.Priorities > maxPriorities {
+		errs = append(errs, fmt.Errorf("priorities must be between 1 and %d, got %d", maxPriorities, c.Priorities))
+	}
+	if c.Ratio != 0 && c.Ratio < 1 {
+		errs = append(errs, fmt.Errorf("ratio must be at least 1, got %v", c.Ratio))
+	}
+	if c.MinimumRate != nil && *c.MinimumRate < 0 {
+		errs = append(errs, fmt.Errorf("minimum rate must not be negative, got %v", *c.MinimumRate))
+	}
+	priorities := c.Priorities
+	if priorities == 0 {
+		priorities = StandardPriorities
+	}
+	for p, k := range c.PriorityRatios {
+		if p < 0 || int(p) >= priorities {
+			errs = append(errs, fmt.Errorf("ratio of priority %d: priority must be in [0, %d)", p, priorities))
+		} else if k < 1 {
+			errs = append(errs, fmt.Errorf("ratio of priority %d must be at least 1, got %v", p, k))
+		}
+	}
+	for p, x := range c.PriorityMinimumRates {
+		if p < 0 || int(p) >= priorities {
+			errs = append(errs, fmt.Errorf("minimum rate of priority %d: priority must be in [0, %d)", p, priorities))
+		} else if x < 0 {
+			errs = append(errs, fmt.Errorf("minimum rate of priority %d must not be negative, got %v", p, x))
+		}
+	}
+	if c.Window < 0 {
+		errs = append(errs, fmt.Errorf("window must not be negative, got %s", time.Duration(c.Window)))
+	} else if c.Window != 0 && time.Duration(c.Window)/windowBuckets < minBucketWidth {
+		errs = append(errs, fmt.Errorf(
+			"window must be at least %s to fit %d buckets, got %s",
+			minBucketWidth*windowBuckets, windowBuckets, time.Duration(c.Window),
+		))
+	}
+	if c.Classifier != "" {
+		if _, ok := ErrorClassifiers[c.Classifier]; !ok {
+			errs = append(errs, fmt.Errorf("unknown classifier %q", c.Classifier))
+		}
+	}
+	if c.InvalidPriority != "" {
+		if _, ok := invalidPriorityPolicies[c.InvalidPriority]; !ok {
+			errs = append(errs, fmt.Errorf("unknown invalid priority policy %q", c.InvalidPriority))
+		}
+	}
+
+	if c.PriorityPolicy != "" {
+		if _, ok := priorityPolicies[c.PriorityPolicy]; !ok {
+			errs = append(errs, fmt.Errorf("unknown priority policy %q", c.PriorityPolicy))
+		}
+	}
+	if len(c.PriorityWeights) > 0 && c.PriorityPolicy != "weighted" {
+		errs = append(errs, errors.New(`priority weights require the "weighted" priority policy`))
+	}
+	for i, w := range c.PriorityWeights {
+		if !validWeight(w) {
+			errs = append(errs, fmt.Errorf("weight of priority %d must be positive and finite, got %v", i, w))
+		}
+	}
+	if c.DeadlineQuantile < 0 || c.DeadlineQuantile >= 1 {
+		errs = append(errs, fmt.Errorf("deadline quantile must be in [0, 1), got %v", c.DeadlineQuantile))
+	}
+
+	return errors.Join(errs...)
+}
+
+// Options returns the options described by the throttle configuration.
+// The configuration is expected to be valid.
+func (c ThrottleConfig) Options() []AdaptiveThrottleOption {
+	var options []AdaptiveThrottleOption
+	if c.Ratio != 0 {
+		options = append(options, WithAdaptiveThrottleRatio(c.Ratio))
+	}
+	if c.MinimumRate != nil {
+		options = append(options, WithAdaptiveThrottleMinimumRate(*c.MinimumRate))
+	}
+	for p, k := range c.PriorityRatios {
+		options = append(options, WithAdaptiveThrottlePriorityRatio(p, k))
+	}
+	for p, x := range c.PriorityMinimumRates {
+		options = append(options, WithAdaptiveThrottlePriorityMinimumRate(p, x))
+	}
+	if c.Window != 0 {
+		options = append(options, WithAdaptiveThrottleWindow(time.Duration(c.Window)))
+	}
+	if fn, ok := ErrorClassifiers[c.Classifier]; ok {
+		options = append(options, WithAdaptiveThrottleRejectedErrors(fn))
+	}
+	if policy, ok := invalidPriorityPolicies[c.InvalidPriority]; ok {
+		options = append(options, WithAdaptiveThrottleInvalidPriority(policy))
+	}
+	if c.PriorityPolicy == "weighted" {
+		options = append(options, WithAdaptiveThrottlePriorityPolicy(WeightedPriority(c.PriorityWeights...)))
+	} else if policy, ok := priorityPolicies[c.PriorityPolicy]; ok {
+		options = append(options, WithAdaptiveThrottlePriorityPolicy(policy))
+	}
+	if c.DryRun {
+		options = append(options, WithAdaptiveThrottleDryRun(true))
+	}
+	if c.TenantFairness {
+		options = append(options, WithAdaptiveThrottleTenantFairness(true))
+	}
+	if c.DeadlineQuantile != 0 {
+		options = append(options, WithAdaptiveThrottleDeadline(c.DeadlineQuantile))
+	}
+
+	return options
+}
+
+// NewAdaptiveThrottle returns the throttle described by the configuration. The
+// given options are applied after the ones from the configuration.
+// The configuration is expected to be valid.
+func (c ThrottleConfig) NewAdaptiveThrottle(options ...AdaptiveThrottleOption) *AdaptiveThrottle {
+	priorities := c.Priorities
+	if priorities == 0 {
+		priorities = StandardPriorities
+	}
+
+	return NewAdaptiveThrottle(priorities, append(c.Options(), options...)...)
+}
+
+// Duration is a time.Duration that is encoded as a string such as "1m30s" in
+// configuration files.
+type Duration time.Duration
+
+// String returns the duration formatted like time.Duration.
+func (d Duration) String() string { return time.Duration(d).String() }
+
+// MarshalText encodes the duration as a string such as "1m30s".
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(time.Duration(d).String()), nil
+}
+
+// UnmarshalText decodes a duration string such as "1m30s".
+func (d *Duration) UnmarshalText(text []byte) error {
+	x, err := time.ParseDuration(string(text))
+	if err != nil {
+		return err
+	}
+	*d = Duration(x)
+
+	return nil
+}
+
+// UnmarshalYAML decodes a duration string such as "1m30s" with YAML decoders
+// that support the unmarshal function interface.
+func (d *Duration) UnmarshalYAML(unmarshal func(any) error) error {
+	var text string
+	if err := unmarshal(&text); err != nil {
+		return err
+	}
+
+	return d.UnmarshalText([]byte(text))
+}
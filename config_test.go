@@ -0,0 +1,106 @@
+package bulwark_test
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/deixis/bulwark"
+)
+
+func TestLoadConfig(t *testing.T) {
+	c, err := bulwark.LoadConfig(strings.NewReader(`{
+		"throttles": {
+			"payments": {"priorities": 2, "ratio": 1.5, "minimum_rate": 0.5, "window": "30s", "priority_minimum_rates": {"0": 20}},
+			"search": {"classifier": "all"}
+		}
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := time.Duration(c.Throttles["payments"].Window); got != 30*time.Second {
+		t.Errorf("expected window to be 30s, got %s", got)
+	}
+
+	r, err := c.NewRegistry()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"payments", "search"} {
+		if _, ok := r.Get(name); !ok {
+			t.Errorf("expected throttle %q to be registered", name)
+		}
+	}
+	payments, _ := r.Get("payments")
+	if got := payments.Stats().Priorities[0].MinimumRate; got != 20 {
+		t.Errorf("expected the minimum rate of priority 0 to be 20, got %v", got)
+	}
+}
+
+func TestLoadConfigValidation(t *testing.T) {
+	table := []struct {
+		name   string
+		config string
+		expect string
+	}{
+		{
+			name:   "Ratio",
+			config: `{"throttles": {"a": {"ratio": 0.5}}}`,
+			expect: "ratio must be at least 1",
+		},
+		{
+			name:   "Negative minimum rate",
+			config: `{"throttles": {"a": {"minimum_rate": -1}}}`,
+			expect: "minimum rate must not be negative",
+		},
+		{
+			name:   "Window",
+			config: `{"throttles": {"a": {"window": "1ms"}}}`,
+			expect: "window must be at least",
+		},
+		{
+			name:   "Classifier",
+			config: `{"throttles": {"a": {"classifier": "nope"}}}`,
+			expect: `unknown classifier "nope"`,
+		},
+		{
+			name:   "Priority policy",
+			config: `{"throttles": {"a": {"priority_policy": "fair"}}}`,
+			expect: `unknown priority policy "fair"`,
+		},
+		{
+			name:   "Priority weights",
+			config: `{"throttles": {"a": {"priority_policy": "weighted", "priority_weights": [1, 0]}}}`,
+			expect: "weight of priority 1 must be positive",
+		},
+		{
+			name:   "Priority ratio",
+			config: `{"throttles": {"a": {"priorities": 2, "priority_ratios": {"2": 1.5}}}}`,
+			expect: "ratio of priority 2: priority must be in [0, 2)",
+		},
+		{
+			name:   "Priority minimum rate",
+			config: `{"throttles": {"a": {"priority_minimum_rates": {"0": -1}}}}`,
+			expect: "minimum rate of priority 0 must not be negative",
+		},
+		{
+			name:   "Deadline quantile",
+			config: `{"throttles": {"a": {"deadline_quantile": 1}}}`,
+			expect: "deadline quantile must be in [0, 1), got 1",
+		},
+		{
+			name:   "Unknown field",
+			config: `{"throttles": {"a": {"ratoi": 2}}}`,
+			expect: "unknown field",
+		},
+	}
+
+	for _, tt := range table {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := bulwark.LoadConfig(strings.NewReader(tt.config))
+			if err == nil || !strings.Contains(err.Error(), tt.expect) {
+				t.Errorf("expected error containing %q, got %v", tt.expect, err)
+			}
+		})
+	}
+}
@@ -3,6 +3,7 @@ package bulwark
 // Source: https://github.com/bradenaw/backpressure
 
 import (
+	"encoding/binary"
 	"time"
 )
 
@@ -66,3 +67,113 @@ func (c *windowedCounter) get(now time.Time) int {
 
 	return c.count
 }
+
+// resize changes the width of the buckets while preserving the counts that still fit in the new
+// window. Each bucket is moved to the new bucket that corresponds to its age, and buckets that are
+// older than the new window are dropped.
+func (c *windowedCounter) resize(now time.Time, width time.Duration) {
+	c.get(now)
+
+	n := len(c.buckets)
+	buckets := make([]int, n)
+	count := 0
+	for i := 0; i < n; i++ {
+		x := c.buckets[(c.head-i+n)%n]
+		if x == 0 {
+			continue
+		}
+
+		age := now.Sub(c.last) + time.Duration(i)*c.width
+		j := int(age / width)
+		if j >= n {
+			continue
+		}
+		buckets[(n-j)%n] += x
+		count += x
+	}
+
+	c.width = width
+	c.last = now
+	c.buckets = buckets
+	c.count = count
+	c.head = 0
+}
+
+// history returns the count of each bucket, from the oldest to the most recent one.
+func (c *windowedCounter) history(now time.Time) []int {
+	c.get(now)
+
+	n := len(c.buckets)
+	history := make([]int, n)
+	for i := 0; i < n; i++ {
+		history[i] = c.buckets[(c.head+1+i)%n]
+	}
+
+	return history
+}
+
+// MarshalBinary encodes the buckets of the counter, and the time at which they were last updated.
+func (c *windowedCounter) MarshalBinary() ([]byte, error) {
+	b := binary.AppendVarint(nil, int64(c.width))
+	b = binary.AppendVarint(b, c.last.UnixNano())
+	b = binary.AppendUvarint(b, uint64(c.head))
+	b = binary.AppendUvarint(b, uint64(len(c.buckets)))
+	for _, x := range c.buckets {
+		b = binary.AppendVarint(b, int64(x))
+	}
+
+	return b, nil
+}
+
+// UnmarshalBinary decodes a counter encoded with MarshalBinary. The counter must be brought up to
+// date with restore before it is used.
+func (c *windowedCounter) UnmarshalBinary(data []byte) error {
+	r := snapshotReader{data: data}
+	width := time.Duration(r.varint())
+	last := time.Unix(0, r.varint())
+	head := r.uvarint()
+	n := r.uvarint()
+	if r.err == nil && (width <= 0 || n != windowBuckets || head >= n) {
+		r.fail("invalid counter")
+	}
+	if r.err != nil {
+		return r.err
+	}
+
+	buckets := make([]int, n)
+	count := 0
+	for i := range buckets {
+		buckets[i] = int(r.varint())
+		if buckets[i] < 0 {
+			r.fail("negative count")
+		}
+		count += buckets[i]
+	}
+	if err := r.close(); err != nil {
+		return err
+	}
+
+	*c = windowedCounter{
+		width:   width,
+		last:    last,
+		count:   count,
+		buckets: buckets,
+		head:    int(head),
+	}
+
+	return nil
+}
+
+// restore brings a counter decoded from a snapshot up to date. The buckets that expired since the
+// snapshot was taken are dropped with the same logic as get, and the remaining ones are resized
+// when the width of the buckets changed.
+func (c *windowedCounter) restore(now time.Time, width time.Duration) {
+	if c.last.After(now) {
+		// The snapshot was taken by a host with a clock ahead of ours
+		c.last = now
+	}
+	c.get(now)
+	if c.width != width {
+		c.resize(now, width)
+	}
+}
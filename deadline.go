@@ -0,0 +1,57 @@
+package bulwark
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// minDeadlineSamples is the number of latencies of a priority required before
+// requests are rejected based on their deadline.
+const minDeadlineSamples = 20
+
+// DeadlineRejectionError is the error returned when the client rejects the
+// request because its deadline is shorter than the typical latency of the
+// backend. It wraps context.DeadlineExceeded, and it is distinct from
+// ClientSideRejectionError.
+var DeadlineRejectionError = fmt.Errorf(
+	"bulwark: deadline shorter than the expected latency: %w", context.DeadlineExceeded,
+)
+
+// WithAdaptiveThrottleDeadline rejects a request locally with DeadlineRejectionError when the
+// time left before the deadline of its context is shorter than the q-th quantile, in `(0, 1)`,
+// of the latencies of the requests of the same priority that completed within the window,
+// whether they succeeded or failed, except the ones rejected by the backend. Such a request is
+// likely to time out anyway, and sending it only adds load to the backend.
+//
+// The requests rejected because of their deadline are not counted as requests, so they do not
+// raise the rejection probability. They are reported separately in the statistics.
+//
+//	bulwark.WithAdaptiveThrottleDeadline(0.5)
+func WithAdaptiveThrottleDeadline(q float64) AdaptiveThrottleOption {
+	return AdaptiveThrottleOption{func(opts *adaptiveThrottleOptions) {
+		opts.deadlineQuantile = q
+	}}
+}
+
+// doomed returns whether a request of the given priority is likely to exceed
+// the deadline of its context.
+func (t *AdaptiveThrottle) doomed(ctx context.Context, p Priority, now time.Time) bool {
+	if !t.deadlines.Load() {
+		return false
+	}
+
+	t.m.Lock()
+	q, d := t.deadlineQuantile, t.d
+	t.m.Unlock()
+	if q <= 0 {
+		return false
+	}
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		return false
+	}
+	latency, ok := t.latencies[int(p)].percentile(q, minDeadlineSamples, now.Add(-d))
+
+	return ok && deadline.Sub(now) < latency
+}
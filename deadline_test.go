@@ -0,0 +1,103 @@
+package bulwark_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/deixis/bulwark"
+)
+
+func TestDeadline(t *testing.T) {
+	now := time.Now()
+	throttle := bulwark.NewAdaptiveThrottle(
+		bulwark.StandardPriorities,
+		bulwark.WithAdaptiveThrottleDeadline(0.5),
+		bulwark.WithAdaptiveThrottleClock(func() time.Time { return now }),
+	)
+	sendErr := func(ctx context.Context, p bulwark.Priority, err error) error {
+		return throttle.Throttle(ctx, p, func(ctx context.Context) error {
+			now = now.Add(100 * time.Millisecond)
+
+			return err
+		})
+	}
+	send := func(ctx context.Context) error {
+		return sendErr(ctx, bulwark.High, nil)
+	}
+	withDeadline := func(d time.Duration) context.Context {
+		ctx, cancel := context.WithDeadline(context.Background(), now.Add(d))
+		t.Cleanup(cancel)
+
+		return ctx
+	}
+
+	// Not enough latencies are known yet
+	if err := send(withDeadline(10 * time.Millisecond)); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	for i := 0; i < 20; i++ {
+		if err := send(context.Background()); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+	}
+
+	err := send(withDeadline(10 * time.Millisecond))
+	if !errors.Is(err, bulwark.DeadlineRejectionError) || !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected a deadline rejection, got %v", err)
+	}
+	if errors.Is(err, bulwark.ClientSideRejectionError) {
+		t.Error("expected a deadline rejection to be distinct from a client-side rejection")
+	}
+	throttle.ForceAdmit(0)
+	if err := send(withDeadline(10 * time.Millisecond)); !errors.Is(err, bulwark.DeadlineRejectionError) {
+		t.Errorf("expected a forced admission to honour the deadline, got %v", err)
+	}
+	throttle.ClearOverride()
+	if err := send(withDeadline(time.Second)); err != nil {
+		t.Errorf("expected a request with enough time left to be admitted, got %v", err)
+	}
+	if err := throttle.Throttle(withDeadline(10*time.Millisecond), bulwark.Low, func(ctx context.Context) error {
+		return nil
+	}); err != nil {
+		t.Errorf("expected the latencies to be tracked by priority, got %v", err)
+	}
+
+	stats := throttle.Stats().Priorities[bulwark.High]
+	if stats.DeadlineRejections != 2 || stats.Rejections != 0 {
+		t.Errorf("expected 2 deadline rejections and no other rejection, got %+v", stats)
+	}
+	if stats.Requests != 22 {
+		t.Errorf("expected deadline rejections not to be counted as requests, got %d", stats.Requests)
+	}
+
+	// The latencies of the requests that failed are tracked too
+	errNotFound := errors.New("not found")
+	for i := 0; i < 20; i++ {
+		if err := sendErr(context.Background(), bulwark.Medium, errNotFound); err != errNotFound {
+			t.Fatalf("expected the error of the backend, got %v", err)
+		}
+	}
+	if err := sendErr(withDeadline(10*time.Millisecond), bulwark.Medium, nil); !errors.Is(err, bulwark.DeadlineRejectionError) {
+		t.Errorf("expected the latencies of failed requests to be tracked, got %v", err)
+	}
+
+	// The latencies expire with the window
+	now = now.Add(2 * time.Minute)
+	if err := send(withDeadline(10 * time.Millisecond)); err != nil {
+		t.Errorf("expected the latencies older than the window to be ignored, got %v", err)
+	}
+
+	// The latencies are not recorded while the deadlines are not checked
+	throttle.Reconfigure(bulwark.WithAdaptiveThrottleDeadline(0))
+	for i := 0; i < 20; i++ {
+		if err := sendErr(context.Background(), bulwark.Low, nil); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+	}
+	throttle.Reconfigure(bulwark.WithAdaptiveThrottleDeadline(0.5))
+	if err := sendErr(withDeadline(10*time.Millisecond), bulwark.Low, nil); err != nil {
+		t.Errorf("expected no latency to be recorded without the deadline option, got %v", err)
+	}
+}
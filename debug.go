@@ -0,0 +1,101 @@
+package bulwark
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// DebugHandler returns an http.Handler that renders the state of every
+// throttle of the registry, so it is possible to see during an incident
+// whether Bulwark is shedding traffic and for which priorities.
+//
+// The state is rendered as HTML, or as JSON when the request has the query
+// parameter `format=json` or accepts `application/json`.
+//
+//	http.Handle("/debug/bulwark", bulwark.DebugHandler(bulwark.DefaultRegistry))
+func DebugHandler(r *Registry) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		stats := r.Stats()
+		if stats == nil {
+			stats = []AdaptiveThrottleStats{}
+		}
+
+		if req.URL.Query().Get("format") == "json" ||
+			strings.Contains(req.Header.Get("Accept"), "application/json") {
+			w.Header().Set("Content-Type", "application/json")
+			enc := json.NewEncoder(w)
+			enc.SetIndent("", "  ")
+			_ = enc.Encode(map[string]any{
+				"time":      Now(),
+				"throttles": stats,
+			})
+
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		_ = debugTemplate.Execute(w, struct {
+			Time      time.Time
+			Throttles []AdaptiveThrottleStats
+		}{
+			Time:      Now(),
+			Throttles: stats,
+		})
+	})
+}
+
+var debugTemplate = template.Must(template.New("debug").Funcs(template.FuncMap{
+	"percent": func(x float64) string {
+		return fmt.Sprintf("%.1f%%", x*100)
+	},
+	"bucket": func(d time.Duration) time.Duration {
+		return d / windowBuckets
+	},
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<title>Bulwark</title>
+<style>
+body { font-family: sans-serif; }
+table { border-collapse: collapse; margin-bottom: 2em; }
+th, td { border: 1px solid #ccc; padding: 0.25em 0.75em; text-align: right; }
+th { background: #eee; }
+td.shedding { color: #b00; font-weight: bold; }
+</style>
+</head>
+<body>
+<h1>Bulwark</h1>
+<p>{{.Time.Format "2006-01-02T15:04:05Z07:00"}}</p>
+{{range .Throttles}}
+<h2>{{.Name}}</h2>
+<p>instance: {{.Instance}}, window: {{.Window}}, ratio: {{.Ratio}}, minimum rate: {{.MinimumRate}}/s{{if .DryRun}}, <strong>dry run</strong>{{end}}</p>
+{{with .ExchangeError}}<p><strong>exchange error: {{.}}</strong></p>{{end}}
+{{with .Override}}<p><strong>override: {{.Mode}}{{if eq .Mode.String "reject"}} priority {{.Priority}} and lower{{end}}{{if not .Expires.IsZero}} until {{.Expires.Format "2006-01-02T15:04:05Z07:00"}}{{end}}</strong></p>{{end}}
+<table>
+<tr><th>priority</th><th>ratio</th><th>minimum rate</th><th>requests</th><th>accepts</th><th>local rejections</th><th>dry-run rejections</th><th>deadline rejections</th><th>rejection probability</th><th>requests per {{bucket .Window}} (oldest first)</th><th>accepts per {{bucket .Window}} (oldest first)</th></tr>
+{{range .Priorities}}
+<tr>
+<td>{{.Priority}}</td>
+<td>{{.Ratio}}</td>
+<td>{{.MinimumRate}}/s</td>
+<td>{{.Requests}}</td>
+<td>{{.Accepts}}</td>
+<td>{{.Rejections}}</td>
+<td>{{.DryRunRejections}}</td>
+<td>{{.DeadlineRejections}}</td>
+<td{{if gt .RejectionProbability 0.0}} class="shedding"{{end}}>{{percent .RejectionProbability}}</td>
+<td>{{range $i, $x := .RequestHistory}}{{if $i}} {{end}}{{$x}}{{end}}</td>
+<td>{{range $i, $x := .AcceptHistory}}{{if $i}} {{end}}{{$x}}{{end}}</td>
+</tr>
+{{end}}
+</table>
+{{else}}
+<p>No registered throttles.</p>
+{{end}}
+</body>
+</html>
+`))
@@ -0,0 +1,46 @@
+package bulwark_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/deixis/bulwark"
+	"github.com/deixis/faults"
+)
+
+func TestDebugHandler(t *testing.T) {
+	r := bulwark.NewRegistry()
+	throttle := bulwark.NewAdaptiveThrottle(
+		bulwark.StandardPriorities,
+		bulwark.WithAdaptiveThrottleName("payments"),
+		bulwark.WithAdaptiveThrottleRegistry(r),
+	)
+	_ = throttle.Throttle(context.Background(), bulwark.Low, func(ctx context.Context) error {
+		return faults.Unavailable(0)
+	})
+	h := bulwark.DebugHandler(r)
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/debug/bulwark", nil))
+	if !strings.Contains(rec.Body.String(), "<h2>payments</h2>") {
+		t.Errorf("expected HTML to render the payments throttle, got %s", rec.Body.String())
+	}
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/debug/bulwark?format=json", nil))
+	var body struct {
+		Throttles []bulwark.AdaptiveThrottleStats `json:"throttles"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	if len(body.Throttles) != 1 || body.Throttles[0].Name != "payments" {
+		t.Fatalf("expected the payments throttle, got %+v", body.Throttles)
+	}
+	if got := body.Throttles[0].Priorities[bulwark.Low].Requests; got != 1 {
+		t.Errorf("expected 1 low priority request, got %d", got)
+	}
+}
@@ -0,0 +1,168 @@
+package bulwark
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// exchangeInterval is the minimum interval between two exchanges of the
+// statistics of a throttle with its peers.
+const exchangeInterval = 100 * time.Millisecond
+
+// errUnnamedThrottle is returned by the stats exchanges for throttles without
+// a name, which cannot be matched with their peers.
+var errUnnamedThrottle = errors.New("bulwark: sharing statistics requires a throttle name")
+
+// throttleInstances numbers the throttles of the process, so a stats exchange
+// can tell apart throttles with the same name.
+var throttleInstances atomic.Uint64
+
+// PriorityCounts are the requests and accepts of a priority within the window
+// of a throttle.
+type PriorityCounts struct {
+	Requests float64 `json:"requests"`
+	Accepts  float64 `json:"accepts"`
+}
+
+// StatsExchange shares the statistics of throttles between peers, such as the
+// processes of a host or the instances of a fleet, so their rejection
+// probability reflects the outcomes of every peer calling the same backend.
+// Throttles are matched by name, so they must have one.
+//
+// Clients with little traffic never reach statistical significance on their
+// own. Sharing outcomes lets them throttle as soon as the backend is
+// overloaded.
+type StatsExchange interface {
+	// Exchange publishes the local counts of the named throttle, indexed by
+	// priority, and returns the sum of the counts published by its peers.
+	// instance identifies the throttle among the throttles of the process,
+	// which may share a name. The counts of a peer that were published more
+	// than window before now are stale, and must be ignored.
+	//
+	// It returns an error when the counts cannot be shared, for example when
+	// the throttle has no name. The throttle then only uses its own counts.
+	//
+	// It is called with the lock of the throttle held, so it should not
+	// block.
+	Exchange(
+		name string, instance uint64, window time.Duration, now time.Time, local []PriorityCounts,
+	) ([]PriorityCounts, error)
+}
+
+// remoteCounts returns the counts of the peers sharing the statistics of the
+// throttle, refreshed at most every exchangeInterval. t.m must be held.
+func (t *AdaptiveThrottle) remoteCounts(now time.Time) []PriorityCounts {
+	if t.exchange == nil {
+		return nil
+	}
+	if !t.exchanged.IsZero() && now.Sub(t.exchanged) < exchangeInterval {
+		return t.remote
+	}
+
+	local := make([]PriorityCounts, len(t.requests))
+	for i := range local {
+		local[i] = PriorityCounts{
+			Requests: float64(t.requests[i].get(now)),
+			Accepts:  float64(t.accepts[i].get(now)),
+		}
+	}
+	t.remote, t.exchangeErr = t.exchange.Exchange(t.name, t.id, t.d, now, local)
+	t.exchanged = now
+
+	return t.remote
+}
+
+// MemoryExchange shares statistics between throttles of the same process. It
+// is mostly useful to test and simulate fleets of clients.
+//
+//	exchange := bulwark.NewMemoryExchange()
+//	a := bulwark.NewAdaptiveThrottle(bulwark.StandardPriorities,
+//		bulwark.WithAdaptiveThrottleStatsExchange(exchange.Peer("a")))
+//	b := bulwark.NewAdaptiveThrottle(bulwark.StandardPriorities,
+//		bulwark.WithAdaptiveThrottleStatsExchange(exchange.Peer("b")))
+//
+// It is safe to use a MemoryExchange concurrently.
+type MemoryExchange struct {
+	m     sync.Mutex
+	peers peerTable
+}
+
+// NewMemoryExchange returns an empty MemoryExchange.
+func NewMemoryExchange() *MemoryExchange {
+	return &MemoryExchange{peers: peerTable{}}
+}
+
+// Peer returns the StatsExchange of the peer with the given ID. Each throttle
+// sharing its statistics should use its own peer.
+func (e *MemoryExchange) Peer(id string) StatsExchange {
+	return memoryPeer{e: e, id: id}
+}
+
+type memoryPeer struct {
+	e  *MemoryExchange
+	id string
+}
+
+func (p memoryPeer) Exchange(
+	name string, instance uint64, window time.Duration, now time.Time, local []PriorityCounts,
+) ([]PriorityCounts, error) {
+	if name == "" {
+		return nil, errUnnamedThrottle
+	}
+
+	p.e.m.Lock()
+	defer p.e.m.Unlock()
+
+	self := peerKey{peer: p.id, instance: instance}
+	p.e.peers.set(self, name, now, local)
+
+	return p.e.peers.sum(self, name, now.Add(-window), len(local)), nil
+}
+
+// peerTable holds the last counts published by each throttle of each peer.
+type peerTable map[peerKey]peerCounts
+
+// peerKey identifies a throttle of a peer.
+type peerKey struct {
+	peer     string
+	instance uint64
+}
+
+type peerCounts struct {
+	name   string
+	at     time.Time
+	counts []PriorityCounts
+}
+
+// set stores the counts published by a throttle of a peer at the given time,
+// unless more recent counts are already stored.
+func (t peerTable) set(key peerKey, name string, at time.Time, counts []PriorityCounts) {
+	if c, ok := t[key]; ok && c.at.After(at) {
+		return
+	}
+	t[key] = peerCounts{name: name, at: at, counts: append([]PriorityCounts(nil), counts...)}
+}
+
+// sum returns the sum of the counts of the named throttle published since the
+// given time by every throttle but self. Stale counts are removed.
+func (t peerTable) sum(self peerKey, name string, since time.Time, priorities int) []PriorityCounts {
+	sum := make([]PriorityCounts, priorities)
+	for key, c := range t {
+		if c.name != name || key == self {
+			continue
+		}
+		if c.at.Before(since) {
+			delete(t, key)
+
+			continue
+		}
+		for i := 0; i < len(c.counts) && i < priorities; i++ {
+			sum[i].Requests += c.counts[i].Requests
+			sum[i].Accepts += c.counts[i].Accepts
+		}
+	}
+
+	return sum
+}
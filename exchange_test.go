@@ -0,0 +1,120 @@
+package bulwark_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/deixis/bulwark"
+	"github.com/deixis/faults"
+)
+
+func TestStatsExchange(t *testing.T) {
+	memory := bulwark.NewMemoryExchange()
+	gossipA, err := bulwark.ListenGossip("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer gossipA.Close()
+	gossipB, err := bulwark.ListenGossip("127.0.0.1:0", gossipA.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer gossipB.Close()
+	if err := gossipA.SetPeers(gossipB.Addr().String()); err != nil {
+		t.Fatal(err)
+	}
+
+	table := []struct {
+		name string
+		a, b bulwark.StatsExchange
+	}{
+		{name: "Memory", a: memory.Peer("a"), b: memory.Peer("b")},
+		{name: "Gossip", a: gossipA, b: gossipB},
+	}
+
+	// Gossip measures the age of the counts with the time they were received,
+	// so the window must be short enough to wait for them to become stale.
+	const window = 500 * time.Millisecond
+	const step = 150 * time.Millisecond
+
+	for _, tt := range table {
+		t.Run(tt.name, func(t *testing.T) {
+			now := time.Now()
+			newThrottle := func(e bulwark.StatsExchange) *bulwark.AdaptiveThrottle {
+				return bulwark.NewAdaptiveThrottle(
+					bulwark.StandardPriorities,
+					bulwark.WithAdaptiveThrottleName("backend"),
+					bulwark.WithAdaptiveThrottleWindow(window),
+					bulwark.WithAdaptiveThrottleRegistry(bulwark.NewRegistry()),
+					bulwark.WithAdaptiveThrottleStatsExchange(e),
+					bulwark.WithAdaptiveThrottleClock(func() time.Time { return now }),
+				)
+			}
+			a, b := newThrottle(tt.a), newThrottle(tt.b)
+			probability := func(throttle *bulwark.AdaptiveThrottle) float64 {
+				return throttle.Stats().Priorities[bulwark.High].RejectionProbability
+			}
+
+			// Only the first client sees the backend failing.
+			for i := 0; i < 100; i++ {
+				_ = a.Throttle(context.Background(), bulwark.High, func(ctx context.Context) error {
+					return faults.Unavailable(0)
+				})
+			}
+			now = now.Add(step)
+			probability(a) // publish
+
+			deadline := time.Now().Add(5 * time.Second)
+			for probability(b) == 0 {
+				if time.Now().After(deadline) {
+					t.Fatal("expected the second client to reject requests")
+				}
+				time.Sleep(10 * time.Millisecond)
+				now = now.Add(step)
+			}
+
+			// The counts of the first client become stale.
+			now = now.Add(2 * time.Minute)
+			time.Sleep(window)
+			if p := probability(b); p != 0 {
+				t.Errorf("expected stale statistics to be ignored, got %v", p)
+			}
+		})
+	}
+}
+
+func TestStatsExchangeInstances(t *testing.T) {
+	memory := bulwark.NewMemoryExchange()
+	peer := memory.Peer("a")
+	now := time.Now()
+	newThrottle := func(name string) *bulwark.AdaptiveThrottle {
+		return bulwark.NewAdaptiveThrottle(
+			bulwark.StandardPriorities,
+			bulwark.WithAdaptiveThrottleName(name),
+			bulwark.WithAdaptiveThrottleRegistry(bulwark.NewRegistry()),
+			bulwark.WithAdaptiveThrottleStatsExchange(peer),
+			bulwark.WithAdaptiveThrottleClock(func() time.Time { return now }),
+		)
+	}
+
+	// Throttles of the same process with the same name share their counts,
+	// rather than overwriting each other.
+	a, b := newThrottle("backend"), newThrottle("backend")
+	for i := 0; i < 100; i++ {
+		_ = a.Throttle(context.Background(), bulwark.High, func(ctx context.Context) error {
+			return faults.Unavailable(0)
+		})
+	}
+	now = now.Add(time.Second)
+	a.Stats() // publish
+	if s := b.Stats(); s.Priorities[bulwark.High].RejectionProbability == 0 || s.ExchangeError != "" {
+		t.Errorf("expected the counts of the other instance to be shared, got %+v", s)
+	}
+
+	// Unnamed throttles cannot be matched with their peers.
+	unnamed := newThrottle("")
+	if s := unnamed.Stats(); s.ExchangeError == "" {
+		t.Error("expected an unnamed throttle to fail to exchange its counts")
+	}
+}
@@ -0,0 +1,78 @@
+package bulwark
+
+import "context"
+
+// fallback calls the fallback functions in order with the error returned by the
+// previous one, until one of them returns nil.
+func fallback(ctx context.Context, err error, local bool, fns []fallbackFn) error {
+	for _, fn := range fns {
+		if err = fn(ctx, err, local); err == nil {
+			return nil
+		}
+	}
+
+	return err
+}
+
+// FallbackChain returns a fallback function that calls the given fallback
+// functions in order with the error returned by the previous one, until one of
+// them returns nil. It returns the result of the last one otherwise.
+//
+//	bulwark.FallbackChain(
+//		bulwark.LocalFallback(bulwark.StaleCacheFallback(cache, key)),
+//		bulwark.StaticFallback(defaultValue),
+//	)
+func FallbackChain[T any](fns ...fallbackArgsFn[T]) fallbackArgsFn[T] {
+	return func(ctx context.Context, err error, local bool) (T, error) {
+		var t T
+		for _, fn := range fns {
+			if t, err = fn(ctx, err, local); err == nil {
+				return t, nil
+			}
+		}
+
+		return t, err
+	}
+}
+
+// StaticFallback returns a fallback function that always returns v, for example
+// a static list of products when personalised recommendations cannot be fetched.
+func StaticFallback[T any](v T) fallbackArgsFn[T] {
+	return func(ctx context.Context, err error, local bool) (T, error) {
+		return v, nil
+	}
+}
+
+// LocalFallback returns a fallback function that only calls fn when the request
+// was rejected locally by the throttle. Otherwise, it returns the error as is.
+func LocalFallback[T any](fn fallbackArgsFn[T]) fallbackArgsFn[T] {
+	return func(ctx context.Context, err error, local bool) (T, error) {
+		if !local {
+			var zero T
+
+			return zero, err
+		}
+
+		return fn(ctx, err, local)
+	}
+}
+
+// StaleCacheFallback returns a fallback function that returns the last
+// successful value stored in the cache under key. It returns the error as is
+// when there is no such value.
+//
+//	cache := bulwark.NewStaleCache[[]Product](5 * time.Minute)
+//	products, err := bulwark.Throttle(ctx, throttle, bulwark.Medium,
+//		cache.Record(userID, fetchRecommendations),
+//		bulwark.StaleCacheFallback(cache, userID),
+//	)
+func StaleCacheFallback[T any](c *StaleCache[T], key string) fallbackArgsFn[T] {
+	return func(ctx context.Context, err error, local bool) (T, error) {
+		if t, ok := c.Get(key); ok {
+			return t, nil
+		}
+		var zero T
+
+		return zero, err
+	}
+}
@@ -0,0 +1,80 @@
+package bulwark_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/deixis/bulwark"
+	"github.com/deixis/faults"
+)
+
+func TestFallbackChain(t *testing.T) {
+	ctx := context.Background()
+	throttle := bulwark.NewAdaptiveThrottle(bulwark.StandardPriorities)
+	errBackend := errors.New("backend error")
+
+	var calls []string
+	record := func(name string, err error) func(ctx context.Context, err error, local bool) error {
+		return func(ctx context.Context, _ error, local bool) error {
+			calls = append(calls, name)
+
+			return err
+		}
+	}
+	err := throttle.Throttle(ctx, bulwark.High, func(ctx context.Context) error {
+		return errBackend
+	}, record("first", errBackend), record("second", nil), record("third", nil))
+	if err != nil {
+		t.Errorf("expected the second fallback to recover, got %v", err)
+	}
+	if len(calls) != 2 || calls[0] != "first" || calls[1] != "second" {
+		t.Errorf("expected the fallbacks to be called in order until one succeeds, got %v", calls)
+	}
+
+	msg, err := bulwark.Throttle(ctx, throttle, bulwark.High, func(ctx context.Context) (string, error) {
+		return "", errBackend
+	}, bulwark.LocalFallback(bulwark.StaticFallback("local")), bulwark.StaticFallback("static"))
+	if err != nil || msg != "static" {
+		t.Errorf("expected the static fallback to be used for remote errors, got %q, %v", msg, err)
+	}
+
+	// Without fallback, a partial value is returned with the error
+	msg, err = bulwark.Throttle(ctx, throttle, bulwark.High, func(ctx context.Context) (string, error) {
+		return "partial", errBackend
+	})
+	if err != errBackend || msg != "partial" {
+		t.Errorf("expected the value and the error of the function, got %q, %v", msg, err)
+	}
+}
+
+func TestStaleCacheFallback(t *testing.T) {
+	now := time.Now()
+	bulwark.Now = func() time.Time { return now }
+	defer func() { bulwark.Now = time.Now }()
+
+	ctx := context.Background()
+	throttle := bulwark.NewAdaptiveThrottle(bulwark.StandardPriorities)
+	cache := bulwark.NewStaleCache[string](time.Minute)
+	call := func(msg string, err error) (string, error) {
+		return bulwark.Throttle(ctx, throttle, bulwark.High,
+			cache.Record("key", func(ctx context.Context) (string, error) {
+				return msg, err
+			}),
+			bulwark.StaleCacheFallback(cache, "key"),
+		)
+	}
+
+	if msg, err := call("fresh", nil); err != nil || msg != "fresh" {
+		t.Fatalf("expected a fresh value, got %q, %v", msg, err)
+	}
+	if msg, err := call("", faults.Unavailable(0)); err != nil || msg != "fresh" {
+		t.Errorf("expected the stale value, got %q, %v", msg, err)
+	}
+
+	now = now.Add(time.Minute)
+	if _, err := call("", faults.Unavailable(0)); !faults.IsUnavailable(err) {
+		t.Errorf("expected the error once the value expired, got %v", err)
+	}
+}
@@ -0,0 +1,182 @@
+package bulwark
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+// maxGossipMessage is the maximum size of a gossip message.
+const maxGossipMessage = 64 * 1024
+
+// gossipOutbox is the number of messages waiting to be sent. Messages are
+// dropped when it is full, as the next ones supersede them.
+const gossipOutbox = 64
+
+// GossipExchange shares the statistics of throttles between the instances of a
+// fleet over UDP. Every time a throttle exchanges its statistics, its counts
+// are sent to every peer, and the last counts received from each peer are
+// added to the local ones until they are older than the window of the
+// throttle. The age of the counts is measured from the time they were
+// received, with the global Now function, so the clocks of the peers do not
+// need to be synchronised.
+//
+// Messages are neither authenticated nor encrypted, so peers should only
+// listen on a private network.
+//
+// It is safe to use a GossipExchange concurrently.
+type GossipExchange struct {
+	conn   net.PacketConn
+	outbox chan []byte
+	closed chan struct{}
+	close  sync.Once
+
+	m       sync.Mutex
+	targets []net.Addr
+	peers   peerTable
+}
+
+// gossipMessage is the payload of a gossip datagram. Messages are JSON
+// encoded, so they are easy to inspect.
+type gossipMessage struct {
+	// Throttle is the name of the throttle.
+	Throttle string `json:"throttle"`
+	// Instance tells apart the throttles of the peer with the same name.
+	Instance uint64 `json:"instance"`
+	// Counts are the counts of the throttle, indexed by priority.
+	Counts []PriorityCounts `json:"counts"`
+}
+
+// ListenGossip returns a GossipExchange listening on the given UDP address,
+// such as ":7946", and sending statistics to the given peers. The peers should
+// not include the exchange itself.
+func ListenGossip(addr string, peers ...string) (*GossipExchange, error) {
+	conn, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("bulwark: listen gossip: %w", err)
+	}
+
+	g := &GossipExchange{
+		conn:   conn,
+		outbox: make(chan []byte, gossipOutbox),
+		closed: make(chan struct{}),
+		peers:  peerTable{},
+	}
+	if err := g.SetPeers(peers...); err != nil {
+		conn.Close()
+
+		return nil, err
+	}
+	go g.receive()
+	go g.send()
+
+	return g, nil
+}
+
+// Addr returns the address on which the exchange listens.
+func (g *GossipExchange) Addr() net.Addr {
+	return g.conn.LocalAddr()
+}
+
+// SetPeers replaces the UDP addresses of the peers to which statistics are
+// sent, for example when the fleet is scaled.
+func (g *GossipExchange) SetPeers(peers ...string) error {
+	targets := make([]net.Addr, 0, len(peers))
+	for _, peer := range peers {
+		addr, err := net.ResolveUDPAddr("udp", peer)
+		if err != nil {
+			return fmt.Errorf("bulwark: resolve gossip peer %q: %w", peer, err)
+		}
+		targets = append(targets, addr)
+	}
+
+	g.m.Lock()
+	g.targets = targets
+	g.m.Unlock()
+
+	return nil
+}
+
+// Close stops listening. Throttles using g stop sharing their statistics.
+func (g *GossipExchange) Close() error {
+	g.close.Do(func() { close(g.closed) })
+
+	return g.conn.Close()
+}
+
+// Exchange sends the counts of the throttle to every peer, and returns the
+// sum of the counts received from them within the window. The counts are sent
+// in the background, and they are dropped when too many messages are waiting.
+//
+// It implements StatsExchange.
+func (g *GossipExchange) Exchange(
+	name string, instance uint64, window time.Duration, _ time.Time, local []PriorityCounts,
+) ([]PriorityCounts, error) {
+	if name == "" {
+		return nil, errUnnamedThrottle
+	}
+	msg, err := json.Marshal(gossipMessage{Throttle: name, Instance: instance, Counts: local})
+	if err != nil {
+		return nil, fmt.Errorf("bulwark: encode gossip: %w", err)
+	}
+	if len(msg) > maxGossipMessage {
+		return nil, fmt.Errorf("bulwark: gossip message too large: %d bytes", len(msg))
+	}
+
+	select {
+	case g.outbox <- msg:
+	default:
+		// Datagrams are best effort, a dropped one is replaced by the next
+	}
+
+	g.m.Lock()
+	defer g.m.Unlock()
+
+	return g.peers.sum(peerKey{}, name, Now().Add(-window), len(local)), nil
+}
+
+// send sends the queued messages to every peer until the exchange is closed.
+func (g *GossipExchange) send() {
+	for {
+		select {
+		case <-g.closed:
+			return
+		case msg := <-g.outbox:
+			g.m.Lock()
+			targets := g.targets
+			g.m.Unlock()
+
+			for _, addr := range targets {
+				// Datagrams are best effort, a lost one is replaced by the next
+				_, _ = g.conn.WriteTo(msg, addr)
+			}
+		}
+	}
+}
+
+// receive stores the counts received from peers until the exchange is closed.
+func (g *GossipExchange) receive() {
+	buf := make([]byte, maxGossipMessage)
+	for {
+		n, addr, err := g.conn.ReadFrom(buf)
+		if errors.Is(err, net.ErrClosed) {
+			return
+		}
+		if err != nil {
+			continue
+		}
+
+		var msg gossipMessage
+		if err := json.Unmarshal(buf[:n], &msg); err != nil || len(msg.Counts) > maxPriorities {
+			continue
+		}
+
+		g.m.Lock()
+		key := peerKey{peer: addr.String(), instance: msg.Instance}
+		g.peers.set(key, msg.Throttle, Now(), msg.Counts)
+		g.m.Unlock()
+	}
+}
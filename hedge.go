@@ -0,0 +1,236 @@
+package bulwark
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultHedgeBudget is the default ratio of requests that may be hedged.
+	DefaultHedgeBudget = 0.1
+	// minHedgeSamples is the number of latencies required before the hedge
+	// delay is derived from a percentile.
+	minHedgeSamples = 20
+	// maxHedgeTokens caps the number of hedges that can be saved up while
+	// requests are fast, so a burst of slow requests cannot be hedged all at
+	// once.
+	maxHedgeTokens = 10
+	// hedgeToken is the budget of a hedge, in thousandths, so the budget of
+	// requests adds up exactly.
+	hedgeToken = 1000
+)
+
+// Hedger decides when a slow request should be hedged, that is, when a backup
+// request should be sent while the first one is still in flight.
+//
+// Naive hedging doubles the load exactly when a backend is struggling. A Hedger
+// only hedges within a budget, and only when the adaptive throttle does not
+// reject any request of the same priority.
+//
+// It is safe to use a Hedger concurrently.
+type Hedger struct {
+	delay      time.Duration
+	percentile float64
+	budget     int // Thousandths of a hedge per request
+	// after returns a channel receiving the time once d elapsed, and a
+	// function stopping the timer.
+	after func(d time.Duration) (<-chan time.Time, func() bool)
+
+	latencies latencyTracker
+
+	m      sync.Mutex
+	tokens int // Thousandths of a hedge
+}
+
+// NewHedger returns a Hedger. Without WithHedgeDelay or WithHedgePercentile,
+// requests are never hedged.
+func NewHedger(options ...HedgeOption) *Hedger {
+	opts := hedgeOptions{
+		budget: DefaultHedgeBudget,
+	}
+	for _, option := range options {
+		option.f(&opts)
+	}
+
+	return &Hedger{
+		delay:      opts.delay,
+		percentile: opts.percentile,
+		budget:     int(math.Round(clamp(0, opts.budget, 1) * hedgeToken)),
+		after: func(d time.Duration) (<-chan time.Time, func() bool) {
+			timer := time.NewTimer(d)
+
+			return timer.C, timer.Stop
+		},
+	}
+}
+
+// Additional options for the Hedger type.
+type HedgeOption struct {
+	f func(*hedgeOptions)
+}
+
+type hedgeOptions struct {
+	delay      time.Duration
+	percentile float64
+	budget     float64
+}
+
+// WithHedgeDelay sets a fixed delay after which a request is hedged. When it is
+// combined with WithHedgePercentile, it is used until enough latencies have
+// been recorded.
+func WithHedgeDelay(d time.Duration) HedgeOption {
+	return HedgeOption{func(opts *hedgeOptions) {
+		opts.delay = d
+	}}
+}
+
+// WithHedgePercentile hedges a request once it has been in flight for longer
+// than the q-th quantile, in `(0, 1)`, of the recent successful requests. For
+// example, 0.95 hedges the slowest 5% of the requests.
+func WithHedgePercentile(q float64) HedgeOption {
+	return HedgeOption{func(opts *hedgeOptions) {
+		opts.percentile = q
+	}}
+}
+
+// WithHedgeBudget sets the maximum ratio, in `[0, 1]`, of requests that may be
+// hedged, with a precision of 0.001. It defaults to DefaultHedgeBudget.
+func WithHedgeBudget(ratio float64) HedgeOption {
+	return HedgeOption{func(opts *hedgeOptions) {
+		opts.budget = ratio
+	}}
+}
+
+// hedgeDelay returns the delay after which a request should be hedged.
+func (h *Hedger) hedgeDelay() (time.Duration, bool) {
+	if h.percentile > 0 {
+		if d, ok := h.latencies.percentile(h.percentile, minHedgeSamples, time.Time{}); ok {
+			return d, true
+		}
+	}
+
+	return h.delay, h.delay > 0
+}
+
+// deposit adds the budget of a new request.
+func (h *Hedger) deposit() {
+	h.m.Lock()
+	h.tokens = min(h.tokens+h.budget, maxHedgeTokens*hedgeToken)
+	h.m.Unlock()
+}
+
+// spend takes the budget of a hedge. It returns false when the budget is
+// exhausted.
+func (h *Hedger) spend() bool {
+	h.m.Lock()
+	defer h.m.Unlock()
+
+	if h.tokens < hedgeToken {
+		return false
+	}
+	h.tokens -= hedgeToken
+
+	return true
+}
+
+// allow returns whether a request of the given priority may be hedged, and
+// takes the budget of the hedge when it may.
+func (h *Hedger) allow(at *AdaptiveThrottle, p Priority) bool {
+	return at.rejectionProbability(p, at.now()) == 0 && h.spend()
+}
+
+// hedgeAttempt is the outcome of a request sent by Hedge.
+type hedgeAttempt[T any] struct {
+	primary bool
+	value   T
+	err     error
+	local   bool
+}
+
+// Hedge sends a request through the adaptive throttle like Throttle and, when
+// it is still in flight after the delay of the hedger, sends a backup request.
+// The first successful response is returned, and the other request is
+// cancelled.
+//
+// The backup request is only sent when the throttle does not reject any request
+// of the same priority, and when the hedge budget allows it. Both requests are
+// counted by the throttle.
+//
+// The fallback functions are called with the error of the last request that
+// failed, when no request succeeded. Without fallback functions, the value and
+// the error of that request are returned.
+func Hedge[T any](
+	ctx context.Context,
+	h *Hedger,
+	at *AdaptiveThrottle,
+	defaultPriority Priority,
+	throttledFn throttledArgsFn[T],
+	fallbackFn ...fallbackArgsFn[T],
+) (T, error) {
+	priority, err := at.priority(ctx, defaultPriority)
+	if err != nil {
+		return FallbackChain(fallbackFn...)(ctx, err, true)
+	}
+	h.deposit()
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	attempts := make(chan hedgeAttempt[T], 2)
+	send := func(primary bool) {
+		a := hedgeAttempt[T]{primary: primary, local: true}
+		a.value, a.err = Throttle(ctx, at, priority, func(ctx context.Context) (T, error) {
+			a.local = false
+
+			return throttledFn(ctx)
+		})
+		attempts <- a
+	}
+	start := at.now()
+	go send(true)
+
+	inFlight := 1
+	var timeout <-chan time.Time
+	if d, ok := h.hedgeDelay(); ok {
+		var stop func() bool
+		timeout, stop = h.after(d)
+		defer stop()
+	}
+
+	var last hedgeAttempt[T]
+	for inFlight > 0 {
+		select {
+		case <-timeout:
+			timeout = nil
+			if h.allow(at, priority) {
+				inFlight++
+				go send(false)
+			}
+		case a := <-attempts:
+			inFlight--
+			if a.err == nil {
+				// The primary request was in flight for at least as long when
+				// the hedge wins, so the delay does not drift down to the
+				// latency of the fastest requests only.
+				if a.primary || inFlight > 0 {
+					now := at.now()
+					h.latencies.observe(now, now.Sub(start))
+				}
+
+				return a.value, nil
+			}
+			if last.err == nil || !a.local {
+				// Prefer the error of a request that reached the backend
+				last = a
+			}
+		}
+	}
+
+	if len(fallbackFn) == 0 {
+		return last.value, last.err
+	}
+
+	return FallbackChain(fallbackFn...)(ctx, last.err, last.local)
+}
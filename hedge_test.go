@@ -0,0 +1,143 @@
+package bulwark
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/deixis/faults"
+)
+
+func TestHedge(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("slow primary", func(t *testing.T) {
+		var now atomic.Pointer[time.Time]
+		start := time.Now()
+		now.Store(&start)
+		throttle := NewAdaptiveThrottle(
+			StandardPriorities,
+			WithAdaptiveThrottleRegistry(NewRegistry()),
+			WithAdaptiveThrottleClock(func() time.Time { return *now.Load() }),
+		)
+		hedger := NewHedger(
+			WithHedgeDelay(10*time.Millisecond),
+			WithHedgeBudget(1),
+		)
+		timers := make(fakeTimers, 1)
+		hedger.after = timers.after
+
+		var calls atomic.Int32
+		go func() {
+			// The primary request is still in flight after the delay
+			timer := <-timers
+			elapsed := start.Add(30 * time.Millisecond)
+			now.Store(&elapsed)
+			timer.expired <- elapsed
+		}()
+		msg, err := Hedge(ctx, hedger, throttle, High, func(ctx context.Context) (string, error) {
+			if calls.Add(1) == 1 {
+				<-ctx.Done()
+
+				return "", ctx.Err()
+			}
+
+			return "hedge", nil
+		})
+		if err != nil || msg != "hedge" {
+			t.Errorf("expected the hedged response, got %q, %v", msg, err)
+		}
+		if n := calls.Load(); n != 2 {
+			t.Errorf("expected 2 calls, got %d", n)
+		}
+
+		// The latency of the primary request is recorded, even though it was
+		// cancelled.
+		if d, ok := hedger.latencies.percentile(0.5, 1, time.Time{}); !ok || d != 30*time.Millisecond {
+			t.Errorf("expected the latency of the primary request to be recorded, got %s", d)
+		}
+	})
+
+	t.Run("budget", func(t *testing.T) {
+		hedger := NewHedger(WithHedgeDelay(time.Millisecond))
+
+		// 10% of 10 requests is exactly one hedge
+		for i := 0; i < 10; i++ {
+			hedger.deposit()
+		}
+		if !hedger.spend() {
+			t.Error("expected a hedge after 10 requests")
+		}
+		for i := 0; i < 5; i++ {
+			hedger.deposit()
+		}
+		if hedger.spend() {
+			t.Error("expected no hedge after 15 requests")
+		}
+
+		// The budget saved up is capped
+		for i := 0; i < 1000; i++ {
+			hedger.deposit()
+		}
+		var hedges int
+		for hedger.spend() {
+			hedges++
+		}
+		if hedges != maxHedgeTokens {
+			t.Errorf("expected %d hedges, got %d", maxHedgeTokens, hedges)
+		}
+	})
+
+	t.Run("throttled", func(t *testing.T) {
+		throttle := NewAdaptiveThrottle(StandardPriorities, WithAdaptiveThrottleRegistry(NewRegistry()))
+		hedger := NewHedger(
+			WithHedgeDelay(time.Millisecond),
+			WithHedgeBudget(1),
+		)
+		hedger.deposit()
+		if !hedger.allow(throttle, High) {
+			t.Error("expected a hedge while the throttle admits every request")
+		}
+
+		for i := 0; i < 100; i++ {
+			_ = throttle.Throttle(ctx, High, func(ctx context.Context) error {
+				return faults.Unavailable(0)
+			})
+		}
+		hedger.deposit()
+		if hedger.allow(throttle, High) {
+			t.Error("expected no hedge while the throttle rejects requests")
+		}
+	})
+
+	t.Run("fallback", func(t *testing.T) {
+		throttle := NewAdaptiveThrottle(
+			StandardPriorities,
+			WithAdaptiveThrottleRegistry(NewRegistry()),
+			WithAdaptiveThrottleRandom(func() float64 { return 0.99 }),
+		)
+		hedger := NewHedger()
+
+		msg, err := Hedge(ctx, hedger, throttle, High, func(ctx context.Context) (string, error) {
+			return "", faults.Unavailable(0)
+		}, func(ctx context.Context, err error, local bool) (string, error) {
+			if local || !faults.IsUnavailable(err) {
+				t.Errorf("expected the error of the backend, got %v (local: %t)", err, local)
+			}
+
+			return "fallback", nil
+		})
+		if err != nil || msg != "fallback" {
+			t.Errorf("expected the fallback response, got %q, %v", msg, err)
+		}
+
+		// Without fallback, the value returned with the error is kept
+		msg, err = Hedge(ctx, hedger, throttle, High, func(ctx context.Context) (string, error) {
+			return "partial", faults.Unavailable(0)
+		})
+		if !faults.IsUnavailable(err) || msg != "partial" {
+			t.Errorf("expected the partial response with the error, got %q, %v", msg, err)
+		}
+	})
+}
@@ -0,0 +1,258 @@
+package bulwark
+
+import (
+	"errors"
+	"hash/fnv"
+	"math"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unsafe"
+)
+
+const (
+	// hostStatsMagic identifies a host statistics file, and the version of its
+	// layout.
+	hostStatsMagic = 0x6b72_6177_6c75_6202
+	// hostStatsSlots is the number of throttles, across every process of the
+	// host, that can share their statistics through a file.
+	hostStatsSlots = 256
+	// hostStatsIdle is the time after which a slot that was not updated may
+	// be claimed, even though its owner PID is running, as the PID may have
+	// been reused by another process.
+	hostStatsIdle = 10 * time.Minute
+
+	// The layout of a slot, in 64-bit words.
+	slotOwner      = 0 // Owner throttle, or 0 when the slot is free. See slotOwnerID.
+	slotSeq        = 1 // Sequence number, odd while the slot is being written
+	slotName       = 2 // Hash of the name of the throttle
+	slotUpdated    = 3 // Time of the last update, in Unix nanoseconds
+	slotPriorities = 4 // Number of priorities
+	slotCounts     = 5 // Requests and accepts of each priority
+	slotWords      = slotCounts + 2*maxPriorities
+
+	// hostStatsWords is the size of a host statistics file, in 64-bit words.
+	// The first word holds hostStatsMagic.
+	hostStatsWords = 1 + hostStatsSlots*slotWords
+	// seqlockRetries is the number of attempts to read a slot that is being
+	// written before giving up on it.
+	seqlockRetries = 8
+)
+
+// errHostStatsClosed is returned by the exchanges of a closed HostStats.
+var errHostStatsClosed = errors.New("bulwark: host statistics closed")
+
+// errHostStatsFull is returned by the exchanges of a HostStats when every slot
+// of the file is taken.
+var errHostStatsFull = errors.New("bulwark: no free host statistics slot")
+
+// HostStats shares the statistics of throttles between the processes of a
+// host through a memory-mapped file. Throttles created with
+// WithAdaptiveThrottleHostStats share their counts with the throttles of the
+// same name in the other processes, so their rejection probability reflects
+// the outcomes of the whole host.
+//
+// Each throttle publishes its counts in its own slot of the file, owned by
+// its process, and reads the slots of the other throttles. The counts of a
+// process that stopped are ignored once they are older than the window of the
+// throttle.
+//
+// It is safe to use a HostStats concurrently.
+type HostStats struct {
+	pid uint64
+
+	m     sync.Mutex
+	mem   []byte
+	slots map[uint64]int // By throttle instance
+}
+
+// OpenHostStats opens the host statistics file at path, creating it when it
+// does not exist. Every process of the host must use the same path.
+func OpenHostStats(path string) (*HostStats, error) {
+	mem, err := mmapFile(path, hostStatsWords*8)
+	if err != nil {
+		return nil, err
+	}
+
+	h := &HostStats{
+		pid:   uint64(os.Getpid()),
+		mem:   mem,
+		slots: map[uint64]int{},
+	}
+	magic := h.word(0)
+	if !atomic.CompareAndSwapUint64(magic, 0, hostStatsMagic) && atomic.LoadUint64(magic) != hostStatsMagic {
+		_ = munmap(mem)
+
+		return nil, errors.New("bulwark: incompatible host statistics file " + path)
+	}
+
+	return h, nil
+}
+
+// Close releases the slots of the process and unmaps the file. Throttles using
+// h stop sharing their statistics.
+func (h *HostStats) Close() error {
+	h.m.Lock()
+	defer h.m.Unlock()
+
+	if h.mem == nil {
+		return nil
+	}
+	for instance, slot := range h.slots {
+		if slot >= 0 {
+			atomic.CompareAndSwapUint64(h.slotWord(slot, slotOwner), slotOwnerID(h.pid, instance), 0)
+		}
+	}
+	err := munmap(h.mem)
+	h.mem = nil
+
+	return err
+}
+
+// Exchange publishes the counts of the throttle in its slot, claiming one when
+// it does not own one, and sums the counts of the other slots.
+//
+// It implements StatsExchange.
+func (h *HostStats) Exchange(
+	name string, instance uint64, window time.Duration, now time.Time, local []PriorityCounts,
+) ([]PriorityCounts, error) {
+	if name == "" {
+		return nil, errUnnamedThrottle
+	}
+
+	h.m.Lock()
+	defer h.m.Unlock()
+
+	if h.mem == nil {
+		return nil, errHostStatsClosed
+	}
+
+	// The slot may have been claimed by another process after being idle
+	owner := slotOwnerID(h.pid, instance)
+	own, ok := h.slots[instance]
+	if !ok || own < 0 || atomic.LoadUint64(h.slotWord(own, slotOwner)) != owner {
+		own = h.claim(owner, now)
+		h.slots[instance] = own
+	}
+	if own < 0 {
+		return nil, errHostStatsFull
+	}
+	hash := nameHash(name)
+	h.write(own, hash, now, local)
+
+	remote := make([]PriorityCounts, len(local))
+	for slot := 0; slot < hostStatsSlots; slot++ {
+		if slot != own {
+			h.read(slot, hash, now.Add(-window), remote)
+		}
+	}
+
+	return remote, nil
+}
+
+// claim takes a free slot, the slot of a process that stopped, or a slot that
+// was idle for hostStatsIdle, for the given owner. It returns -1 when every
+// slot is taken. h.m must be held.
+func (h *HostStats) claim(owner uint64, now time.Time) int {
+	for slot := 0; slot < hostStatsSlots; slot++ {
+		word := h.slotWord(slot, slotOwner)
+		current := atomic.LoadUint64(word)
+		if current != 0 && processAlive(current>>32) {
+			updated := int64(atomic.LoadUint64(h.slotWord(slot, slotUpdated)))
+			if updated >= now.Add(-hostStatsIdle).UnixNano() {
+				continue
+			}
+		}
+		if atomic.CompareAndSwapUint64(word, current, owner) {
+			// The previous owner may have stopped in the middle of a write,
+			// which would leave the slot unreadable.
+			seq := h.slotWord(slot, slotSeq)
+			s := atomic.LoadUint64(seq)
+			atomic.StoreUint64(seq, s+s%2)
+			atomic.StoreUint64(h.slotWord(slot, slotUpdated), uint64(now.UnixNano()))
+
+			return slot
+		}
+	}
+
+	return -1
+}
+
+// slotOwnerID returns the owner word of the slot of a throttle instance: the
+// PID of its process in the upper 32 bits, and the instance in the lower
+// ones, so the throttles of a process do not share a slot.
+func slotOwnerID(pid, instance uint64) uint64 {
+	return pid<<32 | instance&math.MaxUint32
+}
+
+// write publishes the counts of a throttle in a slot owned by the process.
+func (h *HostStats) write(slot int, hash uint64, now time.Time, counts []PriorityCounts) {
+	seq := h.slotWord(slot, slotSeq)
+	atomic.AddUint64(seq, 1)
+	atomic.StoreUint64(h.slotWord(slot, slotName), hash)
+	atomic.StoreUint64(h.slotWord(slot, slotUpdated), uint64(now.UnixNano()))
+	atomic.StoreUint64(h.slotWord(slot, slotPriorities), uint64(len(counts)))
+	for i, c := range counts {
+		atomic.StoreUint64(h.slotWord(slot, slotCounts+2*i), uint64(c.Requests))
+		atomic.StoreUint64(h.slotWord(slot, slotCounts+2*i+1), uint64(c.Accepts))
+	}
+	atomic.AddUint64(seq, 1)
+}
+
+// read adds the counts of a slot to counts, when it holds the statistics of
+// the throttle with the given name hash updated after since.
+func (h *HostStats) read(slot int, hash uint64, since time.Time, counts []PriorityCounts) {
+	if atomic.LoadUint64(h.slotWord(slot, slotOwner)) == 0 {
+		return
+	}
+
+	seq := h.slotWord(slot, slotSeq)
+	values := make([]PriorityCounts, len(counts))
+	for attempt := 0; attempt < seqlockRetries; attempt++ {
+		before := atomic.LoadUint64(seq)
+		if before%2 == 1 {
+			continue
+		}
+		if atomic.LoadUint64(h.slotWord(slot, slotName)) != hash ||
+			int64(atomic.LoadUint64(h.slotWord(slot, slotUpdated))) < since.UnixNano() {
+			return
+		}
+		n := min(int(atomic.LoadUint64(h.slotWord(slot, slotPriorities))), len(values), maxPriorities)
+		for i := 0; i < n; i++ {
+			values[i] = PriorityCounts{
+				Requests: float64(atomic.LoadUint64(h.slotWord(slot, slotCounts+2*i))),
+				Accepts:  float64(atomic.LoadUint64(h.slotWord(slot, slotCounts+2*i+1))),
+			}
+		}
+		if atomic.LoadUint64(seq) != before {
+			continue
+		}
+
+		for i := 0; i < n; i++ {
+			counts[i].Requests += values[i].Requests
+			counts[i].Accepts += values[i].Accepts
+		}
+
+		return
+	}
+}
+
+// word returns the i-th 64-bit word of the file.
+func (h *HostStats) word(i int) *uint64 {
+	return (*uint64)(unsafe.Pointer(&h.mem[i*8]))
+}
+
+// slotWord returns the i-th 64-bit word of a slot.
+func (h *HostStats) slotWord(slot, i int) *uint64 {
+	return h.word(1 + slot*slotWords + i)
+}
+
+// nameHash returns the hash identifying a throttle in the host statistics
+// file.
+func nameHash(name string) uint64 {
+	f := fnv.New64a()
+	_, _ = f.Write([]byte(name))
+
+	return f.Sum64()
+}
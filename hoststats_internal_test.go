@@ -0,0 +1,83 @@
+//go:build unix
+
+package bulwark
+
+import (
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestHostStatsClaim(t *testing.T) {
+	h, err := OpenHostStats(filepath.Join(t.TempDir(), "bulwark"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Close()
+
+	// Every slot is owned by a running process, which may have reused the PID
+	// of the process that claimed it.
+	now := time.Now()
+	other := slotOwnerID(uint64(os.Getppid()), 1)
+	for slot := 0; slot < hostStatsSlots; slot++ {
+		atomic.StoreUint64(h.slotWord(slot, slotOwner), other)
+		atomic.StoreUint64(h.slotWord(slot, slotUpdated), uint64(now.UnixNano()))
+	}
+	local := make([]PriorityCounts, StandardPriorities)
+	if _, err := h.Exchange("backend", 1, time.Minute, now, local); err != errHostStatsFull {
+		t.Fatalf("expected the slots in use not to be claimed, got %v", err)
+	}
+
+	// The slots become free once they are idle.
+	now = now.Add(hostStatsIdle + time.Second)
+	if _, err := h.Exchange("backend", 1, time.Minute, now, local); err != nil {
+		t.Fatal(err)
+	}
+	slot := h.slots[1]
+	if owner := atomic.LoadUint64(h.slotWord(slot, slotOwner)); owner != slotOwnerID(h.pid, 1) {
+		t.Errorf("expected the idle slot to be claimed, got owner %x", owner)
+	}
+
+	// Another throttle of the same process does not share its slot.
+	if _, err := h.Exchange("backend", 2, time.Minute, now, local); err != nil {
+		t.Fatal(err)
+	}
+	if h.slots[2] == slot {
+		t.Error("expected each throttle to have its own slot")
+	}
+
+	// A throttle whose slot was claimed by another process claims a new one.
+	atomic.StoreUint64(h.slotWord(slot, slotOwner), other)
+	atomic.StoreUint64(h.slotWord(slot, slotUpdated), uint64(now.UnixNano()))
+	if _, err := h.Exchange("backend", 1, time.Minute, now, local); err != nil {
+		t.Fatal(err)
+	}
+	if h.slots[1] == slot {
+		t.Error("expected the throttle to claim a new slot")
+	}
+
+	// A slot left in the middle of a write by a process that stopped can be
+	// read once it is claimed again.
+	for slot := 0; slot < hostStatsSlots; slot++ {
+		if atomic.LoadUint64(h.slotWord(slot, slotOwner)) == other {
+			atomic.StoreUint64(h.slotWord(slot, slotSeq), 1)
+		}
+	}
+	published := []PriorityCounts{{Requests: 10, Accepts: 5}, {}, {}, {}}
+	if _, err := h.Exchange("backend", 4, time.Minute, now, published); err != nil {
+		t.Fatal(err)
+	}
+	remote, err := h.Exchange("backend", 5, time.Minute, now, local)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if remote[0].Requests != 10 || remote[0].Accepts != 5 {
+		t.Errorf("expected the counts of the reclaimed slot to be read, got %+v", remote[0])
+	}
+
+	if _, err := h.Exchange("", 3, time.Minute, now, local); err != errUnnamedThrottle {
+		t.Errorf("expected an unnamed throttle to be rejected, got %v", err)
+	}
+}
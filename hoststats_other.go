@@ -0,0 +1,21 @@
+//go:build !unix
+
+package bulwark
+
+import "errors"
+
+// errHostStatsUnsupported is returned by OpenHostStats on platforms without
+// support for memory-mapped files.
+var errHostStatsUnsupported = errors.New("bulwark: host statistics are not supported on this platform")
+
+func mmapFile(path string, size int) ([]byte, error) {
+	return nil, errHostStatsUnsupported
+}
+
+func munmap(mem []byte) error {
+	return nil
+}
+
+func processAlive(pid uint64) bool {
+	return false
+}
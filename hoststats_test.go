@@ -0,0 +1,146 @@
+//go:build unix
+
+package bulwark_test
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/deixis/bulwark"
+	"github.com/deixis/faults"
+)
+
+func TestHostStats(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "bulwark")
+	now := time.Now()
+
+	// Each HostStats stands for a process of the host.
+	newThrottle := func(name string) *bulwark.AdaptiveThrottle {
+		h, err := bulwark.OpenHostStats(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() {
+			if err := h.Close(); err != nil {
+				t.Error(err)
+			}
+		})
+
+		return bulwark.NewAdaptiveThrottle(
+			bulwark.StandardPriorities,
+			bulwark.WithAdaptiveThrottleName(name),
+			bulwark.WithAdaptiveThrottleRegistry(bulwark.NewRegistry()),
+			bulwark.WithAdaptiveThrottleHostStats(h),
+			bulwark.WithAdaptiveThrottleClock(func() time.Time { return now }),
+		)
+	}
+	a, b, other := newThrottle("backend"), newThrottle("backend"), newThrottle("other")
+
+	// Only the first process sees the backend failing.
+	for i := 0; i < 100; i++ {
+		_ = a.Throttle(context.Background(), bulwark.High, func(ctx context.Context) error {
+			return faults.Unavailable(0)
+		})
+	}
+	probability := func(throttle *bulwark.AdaptiveThrottle) float64 {
+		return throttle.Stats().Priorities[bulwark.High].RejectionProbability
+	}
+
+	if p := probability(b); p != 0 {
+		t.Fatalf("expected the second process to share statistics only once published, got %v", p)
+	}
+	now = now.Add(time.Second)
+	if p := probability(a); p == 0 {
+		t.Fatal("expected the first process to reject requests")
+	}
+	if p := probability(b); p == 0 {
+		t.Error("expected the second process to reject requests")
+	}
+	if p := probability(other); p != 0 {
+		t.Errorf("expected a throttle with another name not to reject requests, got %v", p)
+	}
+
+	// The statistics of the first process expire with the window.
+	now = now.Add(2 * time.Minute)
+	if p := probability(b); p != 0 {
+		t.Errorf("expected expired statistics to be ignored, got %v", p)
+	}
+}
+
+func TestHostStatsProcesses(t *testing.T) {
+	// The helper process publishes the counts of a throttle seeing the backend
+	// failing, and waits for its standard input to be closed.
+	if path := os.Getenv("BULWARK_HOST_STATS"); path != "" {
+		h, err := bulwark.OpenHostStats(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer h.Close()
+		throttle := bulwark.NewAdaptiveThrottle(
+			bulwark.StandardPriorities,
+			bulwark.WithAdaptiveThrottleName("backend"),
+			bulwark.WithAdaptiveThrottleRegistry(bulwark.NewRegistry()),
+			bulwark.WithAdaptiveThrottleHostStats(h),
+		)
+		for i := 0; i < 100; i++ {
+			_ = throttle.Throttle(context.Background(), bulwark.High, func(ctx context.Context) error {
+				return faults.Unavailable(0)
+			})
+		}
+		time.Sleep(200 * time.Millisecond)
+		if s := throttle.Stats(); s.ExchangeError != "" {
+			t.Fatal(s.ExchangeError)
+		}
+		fmt.Println("published")
+		_, _ = io.Copy(io.Discard, os.Stdin)
+
+		return
+	}
+
+	path := filepath.Join(t.TempDir(), "bulwark")
+	cmd := exec.Command(os.Args[0], "-test.run=^TestHostStatsProcesses$")
+	cmd.Env = append(os.Environ(), "BULWARK_HOST_STATS="+path)
+	cmd.Stderr = os.Stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		stdin.Close()
+		if err := cmd.Wait(); err != nil {
+			t.Errorf("helper process: %v", err)
+		}
+	}()
+	if line, err := bufio.NewReader(stdout).ReadString('\n'); line != "published\n" {
+		t.Fatalf("helper process: %q, %v", line, err)
+	}
+
+	h, err := bulwark.OpenHostStats(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Close()
+	throttle := bulwark.NewAdaptiveThrottle(
+		bulwark.StandardPriorities,
+		bulwark.WithAdaptiveThrottleName("backend"),
+		bulwark.WithAdaptiveThrottleRegistry(bulwark.NewRegistry()),
+		bulwark.WithAdaptiveThrottleHostStats(h),
+	)
+	if p := throttle.Stats().Priorities[bulwark.High].RejectionProbability; p == 0 {
+		t.Error("expected the counts of the other process to be shared")
+	}
+}
@@ -0,0 +1,49 @@
+//go:build unix
+
+package bulwark
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// mmapFile maps the file at path into memory, creating it or growing it to
+// size bytes when needed.
+func mmapFile(path string, size int) ([]byte, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("bulwark: open host statistics: %w", err)
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("bulwark: open host statistics: %w", err)
+	}
+	if info.Size() < int64(size) {
+		// Growing a file fills it with zeros, which is a valid empty state
+		if err := f.Truncate(int64(size)); err != nil {
+			return nil, fmt.Errorf("bulwark: open host statistics: %w", err)
+		}
+	}
+
+	mem, err := syscall.Mmap(int(f.Fd()), 0, size, syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_SHARED)
+	if err != nil {
+		return nil, fmt.Errorf("bulwark: map host statistics: %w", err)
+	}
+
+	return mem, nil
+}
+
+func munmap(mem []byte) error {
+	return syscall.Munmap(mem)
+}
+
+// processAlive returns whether the process with the given PID is running.
+func processAlive(pid uint64) bool {
+	err := syscall.Kill(int(pid), 0)
+
+	return err == nil || errors.Is(err, syscall.EPERM)
+}
@@ -0,0 +1,61 @@
+package bulwark
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// latencySamples is the number of recent latencies kept by a latencyTracker.
+const latencySamples = 256
+
+// latencyTracker keeps the most recent latencies of calls to compute
+// percentiles.
+type latencyTracker struct {
+	m       sync.Mutex
+	samples []latencySample
+	// next is the index of the next sample to overwrite once samples is full.
+	next int
+}
+
+// latencySample is the latency of a call, and the time at which it completed.
+type latencySample struct {
+	at time.Time
+	d  time.Duration
+}
+
+// observe records the latency of a call that completed at the given time.
+func (l *latencyTracker) observe(at time.Time, d time.Duration) {
+	l.m.Lock()
+	defer l.m.Unlock()
+
+	if len(l.samples) < latencySamples {
+		l.samples = append(l.samples, latencySample{at: at, d: d})
+
+		return
+	}
+	l.samples[l.next] = latencySample{at: at, d: d}
+	l.next = (l.next + 1) % latencySamples
+}
+
+// percentile returns the q-th quantile, in `[0, 1]`, of the latencies of the
+// calls that completed since the given time, or of every recent call when it
+// is zero. It returns false when fewer than min latencies were recorded.
+func (l *latencyTracker) percentile(q float64, min int, since time.Time) (time.Duration, bool) {
+	l.m.Lock()
+	samples := make([]time.Duration, 0, len(l.samples))
+	for _, s := range l.samples {
+		if !s.at.Before(since) {
+			samples = append(samples, s.d)
+		}
+	}
+	l.m.Unlock()
+	if len(samples) == 0 || len(samples) < min {
+		return 0, false
+	}
+
+	sort.Slice(samples, func(i, j int) bool { return samples[i] < samples[j] })
+	i := int(clamp(0, q*float64(len(samples)-1), float64(len(samples)-1)))
+
+	return samples[i], true
+}
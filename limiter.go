@@ -0,0 +1,207 @@
+package bulwark
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// NotSentError is given to the function returned by Limiter.Admit when the
+// request was admitted but not sent, for example because a later stage of a
+// LimiterChain rejected it. The limiter should release what it holds for the
+// request without recording an outcome.
+var NotSentError = errors.New("bulwark: request not sent")
+
+// errPanicked is given to the function returned by Limiter.Admit when the
+// function sending the request panics.
+var errPanicked = errors.New("bulwark: throttled function panicked")
+
+// Limiter decides whether requests may be sent to a backend, and learns from
+// their outcome. AdaptiveThrottle, RateLimiter and Bulkhead are limiters, and
+// limiters can be stacked with Chain.
+type Limiter interface {
+	// Admit decides whether a request may be sent. The default priority is
+	// used when `ctx` does not have a priority set.
+	//
+	// When the request is rejected, Admit returns the error to return to the
+	// caller. Otherwise, it returns the context to send the request with, and
+	// a function that must be called exactly once with the outcome of the
+	// request. That function returns the error to return to the caller.
+	Admit(ctx context.Context, defaultPriority Priority) (context.Context, func(err error) error, error)
+}
+
+// Admit implements Limiter.
+func (t *AdaptiveThrottle) Admit(ctx context.Context, defaultPriority Priority) (context.Context, func(err error) error, error) {
+	priority, start, err := t.admit(ctx, defaultPriority)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	done := func(err error) error {
+		if errors.Is(err, NotSentError) {
+			// Let a queued request take its place
+			t.release()
+
+			return err
+		}
+
+		return t.record(ctx, priority, start, err)
+	}
+
+	return t.withLoadReporter(ctx), done, nil
+}
+
+// Admit implements Limiter. The token taken for a request that is not sent is
+// returned to the bucket.
+func (l *RateLimiter) Admit(ctx context.Context, defaultPriority Priority) (context.Context, func(err error) error, error) {
+	if !l.Allow(ctx, defaultPriority) {
+		return nil, nil, RateLimitRejectionError
+	}
+
+	done := func(err error) error {
+		if errors.Is(err, NotSentError) {
+			l.m.Lock()
+			l.tokens = min(l.tokens+1, l.burst)
+			l.m.Unlock()
+		}
+
+		return err
+	}
+
+	return ctx, done, nil
+}
+
+// LimiterChain is a Limiter that admits a request only when every one of its
+// stages admits it. See Chain.
+type LimiterChain struct {
+	stages []Limiter
+}
+
+// Chain returns a Limiter that stacks the given limiters, for example a rate
+// limiter and an adaptive throttle, so a request is only sent when every
+// stage admits it. The stages are consulted in order, and the remaining
+// stages are skipped once one of them rejects the request. A rejection is
+// returned as a *StageRejectionError that identifies the stage.
+//
+//	chain := bulwark.Chain(limiter, throttle)
+//	err := chain.Throttle(ctx, bulwark.High, fn)
+func Chain(stages ...Limiter) *LimiterChain {
+	return &LimiterChain{stages: stages}
+}
+
+// Admit implements Limiter. The function returned gives the outcome of the
+// request to every stage, and returns it unwrapped from RejectedError.
+func (c *LimiterChain) Admit(ctx context.Context, defaultPriority Priority) (context.Context, func(err error) error, error) {
+	dones := make([]func(err error) error, 0, len(c.stages))
+	for i, stage := range c.stages {
+		stageCtx, done, err := stage.Admit(ctx, defaultPriority)
+		if err != nil {
+			// The request was admitted by the previous stages, but it will not
+			// be sent.
+			for j := len(dones) - 1; j >= 0; j-- {
+				dones[j](NotSentError)
+			}
+
+			return nil, nil, &StageRejectionError{Stage: i, Limiter: stage, Err: err}
+		}
+		ctx = stageCtx
+		dones = append(dones, done)
+	}
+
+	done := func(err error) error {
+		// Every stage learns from the outcome returned by the backend, rather
+		// than from the error returned by the next stage, which may have been
+		// unwrapped.
+		for i := len(dones) - 1; i >= 0; i-- {
+			_ = dones[i](err)
+		}
+		return unwrapRejected(err)
+	}
+
+	return ctx, done, nil
+}
+
+// Throttle sends a request when every stage of the chain admits it. It is
+// like AdaptiveThrottle.Throttle for a chain of limiters.
+func (c *LimiterChain) Throttle(
+	ctx context.Context, defaultPriority Priority, fn throttledFn, fallbackFn ...fallbackFn,
+) error {
+	admitted, done, err := c.Admit(ctx, defaultPriority)
+	if err != nil {
+		return fallback(ctx, err, true, fallbackFn)
+	}
+	if _, err := send(admitted, done, func(ctx context.Context) (struct{}, error) {
+		return struct{}{}, fn(ctx)
+	}); err != nil {
+		return fallback(ctx, err, false, fallbackFn)
+	}
+
+	return nil
+}
+
+// StageRejectionError is returned when a stage of a LimiterChain rejects a
+// request. It wraps the error returned by the stage, so errors.Is still
+// matches errors such as ClientSideRejectionError or RateLimitRejectionError.
+type StageRejectionError struct {
+	// Stage is the index of the stage in the chain.
+	Stage int
+	// Limiter is the stage that rejected the request.
+	Limiter Limiter
+	// Err is the error returned by the stage.
+	Err error
+}
+
+func (err *StageRejectionError) Error() string {
+	return fmt.Sprintf("bulwark: rejected by stage %d: %v", err.Stage, err.Err)
+}
+
+func (err *StageRejectionError) Unwrap() error { return err.Err }
+
+// Limit is like Throttle for any Limiter, such as a LimiterChain.
+//
+// When the request is rejected locally or `throttledFn` returns an error, the
+// fallback functions are called in order with the error returned by the
+// previous one, until one of them returns nil. See FallbackChain. Without
+// fallback functions, the value returned by `throttledFn` is returned with its
+// error.
+func Limit[T any](
+	ctx context.Context,
+	l Limiter,
+	defaultPriority Priority,
+	throttledFn throttledArgsFn[T],
+	fallbackFn ...fallbackArgsFn[T],
+) (T, error) {
+	admitted, done, err := l.Admit(ctx, defaultPriority)
+	if err != nil {
+		return FallbackChain(fallbackFn...)(ctx, err, true)
+	}
+
+	t, err := send(admitted, done, throttledFn)
+	if err != nil && len(fallbackFn) > 0 {
+		return FallbackChain(fallbackFn...)(ctx, err, false)
+	}
+
+	return t, err
+}
+
+// send sends an admitted request with fn, and gives its outcome to done. When
+// fn panics, done is called with a failure before the panic is propagated, so
+// the limiter releases what it holds for the request.
+func send[T any](ctx context.Context, done func(err error) error, fn throttledArgsFn[T]) (t T, err error) {
+	sent := false
+	defer func() {
+		if sent {
+			return
+		}
+		r := recover()
+		_ = done(errPanicked)
+		if r != nil {
+			panic(r)
+		}
+	}()
+
+	t, err = fn(ctx)
+	sent = true
+
+	return t, done(err)
+}
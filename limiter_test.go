@@ -0,0 +1,99 @@
+package bulwark_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/deixis/bulwark"
+)
+
+func TestChain(t *testing.T) {
+	now := time.Now()
+	clock := func() time.Time { return now }
+	limiter := bulwark.NewRateLimiter(
+		bulwark.StandardPriorities, 0, 2,
+		bulwark.WithRateLimiterReserve(0),
+		bulwark.WithRateLimiterClock(clock),
+	)
+	throttle := bulwark.NewAdaptiveThrottle(
+		bulwark.StandardPriorities,
+		bulwark.WithAdaptiveThrottleClock(clock),
+		bulwark.WithAdaptiveThrottleRandom(func() float64 { return 0.5 }),
+	)
+	chain := bulwark.Chain(limiter, throttle)
+
+	// Every stage learns from the outcome of the request
+	errBackend := errors.New("backend failure")
+	err := chain.Throttle(context.Background(), bulwark.High, func(ctx context.Context) error {
+		return bulwark.RejectedError(errBackend)
+	})
+	if err != errBackend {
+		t.Errorf("expected the error of the backend, got %v", err)
+	}
+	if p := throttle.Stats().Priorities[bulwark.High]; p.Requests != 1 || p.Accepts != 0 {
+		t.Errorf("expected the throttle to record the rejection, got %+v", p)
+	}
+	now = now.Add(time.Second)
+	err = chain.Throttle(context.Background(), bulwark.High, func(ctx context.Context) error {
+		return fmt.Errorf("call: %w", bulwark.RejectedError(errBackend))
+	})
+	if err != errBackend {
+		t.Errorf("expected the error of the backend to be unwrapped, got %v", err)
+	}
+	if p := throttle.Stats().Priorities[bulwark.High]; p.Requests != 2 || p.Accepts != 0 {
+		t.Errorf("expected the throttle to record the wrapped rejection, got %+v", p)
+	}
+	limiter = bulwark.NewRateLimiter(
+		bulwark.StandardPriorities, 0, 2,
+		bulwark.WithRateLimiterReserve(0),
+		bulwark.WithRateLimiterClock(clock),
+	)
+	chain = bulwark.Chain(limiter, throttle)
+
+	// A rejection of the throttle gives back the token of the limiter
+	throttle.ForceReject(bulwark.Low, 0)
+	err = chain.Throttle(context.Background(), bulwark.Low, func(ctx context.Context) error {
+		t.Error("expected the request not to be sent")
+
+		return nil
+	})
+	var stageErr *bulwark.StageRejectionError
+	if !errors.As(err, &stageErr) || stageErr.Stage != 1 || stageErr.Limiter != throttle ||
+		!errors.Is(err, bulwark.ClientSideRejectionError) || errors.Is(err, bulwark.RateLimitRejectionError) {
+		t.Errorf("expected a rejection from the throttle, got %v", err)
+	}
+	throttle.ClearOverride()
+
+	v, err := bulwark.Limit(context.Background(), chain, bulwark.High, func(ctx context.Context) (string, error) {
+		return "ok", nil
+	})
+	if v != "ok" || err != nil {
+		t.Errorf("expected the request to be sent, got %q and %v", v, err)
+	}
+
+	v, err = bulwark.Limit(context.Background(), chain, bulwark.High, func(ctx context.Context) (string, error) {
+		return "partial", errBackend
+	})
+	if v != "partial" || err != errBackend {
+		t.Errorf("expected the partial value with the error, got %q and %v", v, err)
+	}
+
+	_, err = bulwark.Limit(context.Background(), chain, bulwark.High, func(ctx context.Context) (string, error) {
+		t.Error("expected the request not to be sent")
+
+		return "", nil
+	}, bulwark.StaticFallback("fallback"))
+	if err != nil {
+		t.Errorf("expected the fallback to be used, got %v", err)
+	}
+	_, err = bulwark.Limit(context.Background(), chain, bulwark.High, func(ctx context.Context) (string, error) {
+		return "", nil
+	})
+	if !errors.As(err, &stageErr) || stageErr.Stage != 0 || stageErr.Limiter != limiter ||
+		!errors.Is(err, bulwark.RateLimitRejectionError) {
+		t.Errorf("expected a rejection from the rate limiter, got %v", err)
+	}
+}
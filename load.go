@@ -0,0 +1,257 @@
+package bulwark
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// LoadReportHeader is the header, or gRPC trailer, in which ORCA backends
+	// return their load report.
+	LoadReportHeader = "endpoint-load-metrics"
+
+	// loadSmoothing is the weight of a new report in the load of a
+	// LoadTracker.
+	loadSmoothing = 0.3
+)
+
+// LoadReport is the load advertised by a backend, for example in the
+// `endpoint-load-metrics` header of its responses, as defined by ORCA (Open
+// Request Cost Aggregation).
+type LoadReport struct {
+	// CPUUtilization is the CPU utilization of the backend, usually in
+	// `[0, 1]`.
+	CPUUtilization float64 `json:"cpu_utilization,omitempty"`
+	// MemUtilization is the memory utilization of the backend, in `[0, 1]`.
+	MemUtilization float64 `json:"mem_utilization,omitempty"`
+	// ApplicationUtilization is a utilization defined by the application,
+	// such as the depth of a queue relative to its capacity. It may exceed 1.
+	ApplicationUtilization float64 `json:"application_utilization,omitempty"`
+	// RPSFractional is the number of requests per second served by the
+	// backend.
+	RPSFractional float64 `json:"rps_fractional,omitempty"`
+	// EPS is the number of errors per second returned by the backend.
+	EPS float64 `json:"eps,omitempty"`
+	// NamedMetrics are the other metrics of the report.
+	NamedMetrics map[string]float64 `json:"named_metrics,omitempty"`
+}
+
+// Utilization returns the application utilization of the report, or its CPU
+// utilization when the backend does not report one.
+func (r LoadReport) Utilization() float64 {
+	if r.ApplicationUtilization > 0 {
+		return r.ApplicationUtilization
+	}
+
+	return r.CPUUtilization
+}
+
+// ParseLoadReport parses an ORCA load report in the TEXT or JSON format of the
+// `endpoint-load-metrics` header:
+//
+//	TEXT cpu_utilization=0.3, mem_utilization=0.8, named_metrics.queue=12
+//	JSON {"cpu_utilization": 0.3, "mem_utilization": 0.8, "named_metrics": {"queue": 12}}
+//
+// The BIN format is not supported.
+func ParseLoadReport(s string) (LoadReport, error) {
+	format, payload, _ := strings.Cut(strings.TrimSpace(s), " ")
+	switch format {
+	case "TEXT":
+		return parseTextLoadReport(payload)
+	case "JSON":
+		var r LoadReport
+		if err := json.Unmarshal([]byte(payload), &r); err != nil {
+			return LoadReport{}, fmt.Errorf("bulwark: parse load report: %w", err)
+		}
+
+		return r, nil
+	default:
+		return LoadReport{}, fmt.Errorf("bulwark: parse load report: unsupported format %q", format)
+	}
+}
+
+func parseTextLoadReport(payload string) (LoadReport, error) {
+	var r LoadReport
+	for _, field := range strings.Split(payload, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+
+		key, value, ok := strings.Cut(field, "=")
+		if !ok {
+			return LoadReport{}, fmt.Errorf("bulwark: parse load report: invalid field %q", field)
+		}
+		x, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+		if err != nil {
+			return LoadReport{}, fmt.Errorf("bulwark: parse load report: field %q: %w", key, err)
+		}
+
+		switch key = strings.TrimSpace(key); key {
+		case "cpu_utilization":
+			r.CPUUtilization = x
+		case "mem_utilization":
+			r.MemUtilization = x
+		case "application_utilization":
+			r.ApplicationUtilization = x
+		case "rps_fractional":
+			r.RPSFractional = x
+		case "eps":
+			r.EPS = x
+		default:
+			name, ok := strings.CutPrefix(key, "named_metrics.")
+			if !ok {
+				// Ignore unknown fields, so newer backends can add fields
+				continue
+			}
+			if r.NamedMetrics == nil {
+				r.NamedMetrics = map[string]float64{}
+			}
+			r.NamedMetrics[name] = x
+		}
+	}
+
+	return r, nil
+}
+
+// LoadSource provides the utilization of a backend, where 1 means that the
+// backend is fully utilised. It returns false when the utilization is
+// unknown, for example when the backend did not report it recently.
+type LoadSource interface {
+	Load() (utilization float64, ok bool)
+}
+
+// LoadReporter is implemented by load sources that are fed with the load
+// reports of the backend, such as LoadTracker. When the load source of a
+// throttle implements it, ReportLoad can be called from the throttled
+// functions.
+type LoadReporter interface {
+	ReportLoad(r LoadReport)
+}
+
+// LoadTracker is a LoadSource that smooths the utilization reported by a
+// backend. Reports older than its TTL are forgotten, so a throttle does not
+// keep shedding traffic based on an old report.
+//
+// It is safe to use a LoadTracker concurrently.
+type LoadTracker struct {
+	ttl time.Duration
+
+	m           sync.Mutex
+	utilization float64
+	updated     time.Time
+}
+
+// NewLoadTracker returns a LoadTracker that forgets reports after ttl.
+func NewLoadTracker(ttl time.Duration) *LoadTracker {
+	return &LoadTracker{ttl: ttl}
+}
+
+// ReportLoad records a load report of the backend.
+func (l *LoadTracker) ReportLoad(r LoadReport) {
+	now := Now()
+
+	l.m.Lock()
+	defer l.m.Unlock()
+
+	if l.updated.IsZero() || now.Sub(l.updated) >= l.ttl {
+		l.utilization = r.Utilization()
+	} else {
+		l.utilization += loadSmoothing * (r.Utilization() - l.utilization)
+	}
+	l.updated = now
+}
+
+// Load returns the smoothed utilization of the backend, unless no report was
+// recorded within the TTL of the tracker.
+func (l *LoadTracker) Load() (float64, bool) {
+	now := Now()
+
+	l.m.Lock()
+	defer l.m.Unlock()
+
+	if l.updated.IsZero() || now.Sub(l.updated) >= l.ttl {
+		return 0, false
+	}
+
+	return l.utilization, true
+}
+
+type loadReporterKey struct{}
+
+var activeLoadReporterKey = loadReporterKey{}
+
+// ReportLoad records the load report of a backend from a throttled function,
+// for the throttle that called it. It does nothing when the load source of the
+// throttle does not implement LoadReporter.
+//
+//	bulwark.Throttle(ctx, throttle, bulwark.High, func(ctx context.Context) (*http.Response, error) {
+//		res, err := client.Do(req.WithContext(ctx))
+//		if err == nil {
+//			if r, err := bulwark.ParseLoadReport(res.Header.Get(bulwark.LoadReportHeader)); err == nil {
+//				bulwark.ReportLoad(ctx, r)
+//			}
+//		}
+//		return res, err
+//	})
+func ReportLoad(ctx context.Context, r LoadReport) {
+	if reporter, ok := ctx.Value(activeLoadReporterKey).(LoadReporter); ok {
+		reporter.ReportLoad(r)
+	}
+}
+
+// WithAdaptiveThrottleLoad uses the utilization of the backend provided by source as an additional
+// input to the rejection probability, so the throttle eases off before the backend starts failing.
+//
+// Requests are shed so as to bring the utilization back under a target: the lowest priority
+// targets the given utilization, the highest priority a utilization of 1, and the priorities in
+// between are spread evenly. For example, with a target of 0.8 and a utilization of 1, a fifth of
+// the lowest priority requests are rejected. The rejection probability is the highest of the
+// load-based and the error-based probabilities. The target must be in `(0, 1]`, or
+// WithAdaptiveThrottleLoad panics.
+//
+// This option is ignored by Reconfigure.
+func WithAdaptiveThrottleLoad(source LoadSource, target float64) AdaptiveThrottleOption {
+	if !(target > 0 && target <= 1) {
+		panic(fmt.Sprintf("bulwark: load target must be in (0, 1], got %v", target))
+	}
+
+	return AdaptiveThrottleOption{func(opts *adaptiveThrottleOptions) {
+		opts.load = source
+		opts.loadTarget = target
+	}}
+}
+
+// loadRejectionProbability returns the probability that a request of the
+// given priority is rejected based on the utilization of the backend.
+func (t *AdaptiveThrottle) loadRejectionProbability(p Priority) float64 {
+	if t.load == nil {
+		return 0
+	}
+	utilization, ok := t.load.Load()
+	if !ok || utilization <= 0 {
+		return 0
+	}
+
+	target := t.loadTarget
+	if n := len(t.requests); n > 1 {
+		target += (1 - t.loadTarget) * float64(n-1-int(p)) / float64(n-1)
+	}
+
+	return clamp(0, 1-target/utilization, 1)
+}
+
+// withLoadReporter attaches the load reporter of the throttle to the context
+// given to throttled functions, if any.
+func (t *AdaptiveThrottle) withLoadReporter(ctx context.Context) context.Context {
+	if reporter, ok := t.load.(LoadReporter); ok {
+		return context.WithValue(ctx, activeLoadReporterKey, reporter)
+	}
+
+	return ctx
+}
@@ -0,0 +1,101 @@
+package bulwark_test
+
+import (
+	"context"
+	"math"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/deixis/bulwark"
+)
+
+func TestParseLoadReport(t *testing.T) {
+	table := []struct {
+		name   string
+		header string
+		expect bulwark.LoadReport
+		err    bool
+	}{
+		{
+			name:   "Text",
+			header: "TEXT cpu_utilization=0.3, mem_utilization=0.8, named_metrics.queue=12, unknown=1",
+			expect: bulwark.LoadReport{
+				CPUUtilization: 0.3,
+				MemUtilization: 0.8,
+				NamedMetrics:   map[string]float64{"queue": 12},
+			},
+		},
+		{
+			name:   "JSON",
+			header: `JSON {"application_utilization": 1.2, "rps_fractional": 100}`,
+			expect: bulwark.LoadReport{ApplicationUtilization: 1.2, RPSFractional: 100},
+		},
+		{
+			name:   "Invalid value",
+			header: "TEXT cpu_utilization=high",
+			err:    true,
+		},
+		{
+			name:   "Binary",
+			header: "BIN CgkJMzMzMzMz0z8=",
+			err:    true,
+		},
+	}
+
+	for _, tt := range table {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := bulwark.ParseLoadReport(tt.header)
+			if tt.err {
+				if err == nil {
+					t.Errorf("expected an error, got %+v", r)
+				}
+
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(r, tt.expect) {
+				t.Errorf("expected %+v, got %+v", tt.expect, r)
+			}
+		})
+	}
+}
+
+func TestThrottleLoad(t *testing.T) {
+	tracker := bulwark.NewLoadTracker(time.Minute)
+	throttle := bulwark.NewAdaptiveThrottle(
+		bulwark.StandardPriorities,
+		bulwark.WithAdaptiveThrottleLoad(tracker, 0.7),
+	)
+
+	// The backend reports that it is fully utilised, without failing.
+	err := throttle.Throttle(context.Background(), bulwark.High, func(ctx context.Context) error {
+		bulwark.ReportLoad(ctx, bulwark.LoadReport{CPUUtilization: 1})
+
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stats := throttle.Stats()
+	// The targets are 1, 0.9, 0.8 and 0.7 from the highest priority
+	for p, expect := range []float64{0, 0.1, 0.2, 0.3} {
+		if got := stats.Priorities[p].RejectionProbability; math.Abs(got-expect) > 1e-9 {
+			t.Errorf("expected a rejection probability of %v for priority %d, got %v", expect, p, got)
+		}
+	}
+
+	for _, target := range []float64{0, -0.5, 1.5, math.NaN()} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("expected a panic with a load target of %v", target)
+				}
+			}()
+			bulwark.WithAdaptiveThrottleLoad(tracker, target)
+		}()
+	}
+}
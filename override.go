@@ -0,0 +1,135 @@
+package bulwark
+
+import (
+	"context"
+	"time"
+)
+
+// OverrideMode is the kind of manual override applied to a throttle.
+type OverrideMode int
+
+const (
+	// OverrideAdmit admits every request, regardless of the rejection
+	// probability.
+	OverrideAdmit OverrideMode = iota + 1
+	// OverrideReject rejects every request of a given priority or of a lower
+	// priority.
+	OverrideReject
+)
+
+func (m OverrideMode) String() string {
+	switch m {
+	case OverrideAdmit:
+		return "admit"
+	case OverrideReject:
+		return "reject"
+	default:
+		return "none"
+	}
+}
+
+// MarshalText encodes the mode as a string.
+func (m OverrideMode) MarshalText() ([]byte, error) {
+	return []byte(m.String()), nil
+}
+
+// Override is a manual override of the decisions of a throttle, set by an
+// operator during an incident.
+type Override struct {
+	Mode OverrideMode `json:"mode"`
+	// Priority is the most important priority rejected by OverrideReject.
+	Priority Priority `json:"priority"`
+	// Expires is the time at which the override is lifted automatically. A
+	// zero value means that the override never expires.
+	Expires time.Time `json:"expires,omitempty"`
+}
+
+// ForceAdmit disables shedding: every request is sent to the backend until the
+// override is cleared, or until ttl elapses when it is not zero. The outcomes of
+// the requests are still recorded. Requests are still rejected when their
+// deadline is too short or when they exceed the rate limiter of the throttle,
+// if any.
+//
+// It replaces any active override.
+func (t *AdaptiveThrottle) ForceAdmit(ttl time.Duration) {
+	t.setOverride(Override{Mode: OverrideAdmit}, ttl)
+}
+
+// ForceReject rejects every request of priority p or of a lower priority
+// (e.g. `Medium` and `Low` for `Medium`) locally, without waiting for the window
+// statistics to catch up. It lasts until the override is cleared, or until ttl
+// elapses when it is not zero. Forced rejections are not counted as requests,
+// so they do not affect the rejection probability once the override is lifted.
+//
+// It replaces any active override.
+func (t *AdaptiveThrottle) ForceReject(p Priority, ttl time.Duration) {
+	t.setOverride(Override{Mode: OverrideReject, Priority: p}, ttl)
+}
+
+// ClearOverride lifts the active override, if any.
+func (t *AdaptiveThrottle) ClearOverride() {
+	t.m.Lock()
+	t.override = nil
+	t.m.Unlock()
+}
+
+// Override returns the active override, if any.
+func (t *AdaptiveThrottle) Override() (Override, bool) {
+	now := t.now()
+
+	t.m.Lock()
+	defer t.m.Unlock()
+
+	return t.activeOverride(now)
+}
+
+func (t *AdaptiveThrottle) setOverride(o Override, ttl time.Duration) {
+	if ttl > 0 {
+		o.Expires = t.now().Add(ttl)
+	}
+
+	t.m.Lock()
+	t.override = &o
+	t.m.Unlock()
+}
+
+// activeOverride returns the active override, and lifts it when it has
+// expired. t.m must be held.
+func (t *AdaptiveThrottle) activeOverride(now time.Time) (Override, bool) {
+	if t.override == nil {
+		return Override{}, false
+	}
+	if !t.override.Expires.IsZero() && !now.Before(t.override.Expires) {
+		t.override = nil
+
+		return Override{}, false
+	}
+
+	return *t.override, true
+}
+
+// overridden applies the active override to a request. It returns whether the
+// decision was made by the override, and the error to return when the request
+// is rejected.
+func (t *AdaptiveThrottle) overridden(ctx context.Context, p Priority, now time.Time) (bool, error) {
+	t.m.Lock()
+	o, ok := t.activeOverride(now)
+	if ok && o.Mode == OverrideReject && p >= o.Priority {
+		t.rejects[int(p)].add(now, 1)
+	}
+	t.m.Unlock()
+	if !ok {
+		return false, nil
+	}
+
+	switch {
+	case o.Mode == OverrideAdmit:
+		return true, nil
+	case o.Mode == OverrideReject && p >= o.Priority:
+		t.notify(ctx, p, 1, false, ClientSideRejectionError)
+
+		return true, ClientSideRejectionError
+	default:
+		return false, nil
+	}
+}
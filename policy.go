@@ -0,0 +1,138 @@
+package bulwark
+
+import (
+	"fmt"
+	"math"
+	"sync"
+)
+
+// PriorityPolicy computes the probability that a request of priority p will be
+// rejected, from the state of every priority of the throttle within its time
+// window. The result must be in `[0, 1]`.
+//
+// A policy is called for every request, with the lock of the throttle held, so
+// it must not call the throttle. The windows must not be retained after the
+// call.
+//
+// It decides how the priorities interact: whether the failures of the
+// higher priorities are shed from the lower ones, and how much of the
+// capacity of the backend each priority is guaranteed.
+type PriorityPolicy func(p Priority, windows []PriorityWindow) float64
+
+// PriorityWindow is the state of a priority within the time window of a
+// throttle.
+type PriorityWindow struct {
+	// Requests is the number of requests, including the ones rejected locally.
+	Requests float64
+	// Accepts is the number of requests accepted by the backend.
+	Accepts float64
+	// Ratio is the accept multiplier. See WithAdaptiveThrottleRatio.
+	Ratio float64
+	// MinimumRequests is the minimum number of requests sent to the backend
+	// within the window. See WithAdaptiveThrottleMinimumRate.
+	MinimumRequests float64
+}
+
+// Probability returns the rejection probability of the priority on its own,
+// using the formula from https://sre.google/sre-book/handling-overload/:
+//
+//	clamp(0, (requests - ratio * accepts) / (requests + minimumRequests), 1)
+func (w PriorityWindow) Probability() float64 {
+	return adaptiveProbability(w.Requests, w.Accepts, w.Ratio, w.MinimumRequests)
+}
+
+var (
+	// StrictPriority counts the requests of every higher priority that were
+	// not accepted as non-accepted for the lower priorities, so lower
+	// priorities are shed before any higher priority is. A low priority can be
+	// starved completely while the higher ones exceed the capacity of the
+	// backend. This is the default policy.
+	StrictPriority PriorityPolicy = func(p Priority, windows []PriorityWindow) float64 {
+		w := windows[p]
+		requests := w.Requests
+		for i := 0; i < int(p); i++ {
+			requests += windows[i].Requests - windows[i].Accepts
+		}
+
+		return adaptiveProbability(requests, w.Accepts, w.Ratio, w.MinimumRequests)
+	}
+	// IndependentPriority throttles every priority on its own, as if each of
+	// them had its own throttle.
+	IndependentPriority PriorityPolicy = func(p Priority, windows []PriorityWindow) float64 {
+		return windows[p].Probability()
+	}
+)
+
+// WeightedPriority shares the requests admitted by the throttle between
+// priorities in proportion to their weight, given in priority order. A
+// priority sending fewer requests than its share is not throttled, and the
+// capacity it leaves is shared between the other priorities, so every priority
+// keeps a guaranteed trickle under overload.
+//
+// The number of requests admitted overall is computed from the requests and
+// accepts of every priority. Weights must be positive and finite, or
+// WeightedPriority panics. Priorities without a weight have a weight of 1.
+//
+//	// Low is guaranteed 10% of the capacity
+//	bulwark.WeightedPriority(4, 3, 2, 1)
+func WeightedPriority(weights ...float64) PriorityPolicy {
+	for i, w := range weights {
+		if !validWeight(w) {
+			panic(fmt.Sprintf("bulwark: weight of priority %d must be positive and finite, got %v", i, w))
+		}
+	}
+	weights = append([]float64(nil), weights...)
+	weight := func(p int) float64 {
+		if p < len(weights) {
+			return weights[p]
+		}
+
+		return 1
+	}
+	// The demands are reused between calls, as the policy is called for every
+	// request.
+	buffers := sync.Pool{New: func() any { return new([]fairDemand) }}
+
+	return func(p Priority, windows []PriorityWindow) float64 {
+		var requests, accepts float64
+		for _, w := range windows {
+			requests += w.Requests
+			accepts += w.Accepts
+		}
+
+		if requests == 0 {
+			return 0
+		}
+
+		demands := buffers.Get().(*[]fairDemand)
+		defer buffers.Put(demands)
+		*demands = (*demands)[:0]
+		for i, w := range windows {
+			*demands = append(*demands, fairDemand{demand: w.Requests, weight: weight(i)})
+		}
+
+		w := windows[p]
+		admitted := requests * (1 - adaptiveProbability(requests, accepts, w.Ratio, w.MinimumRequests))
+		share := fairShare(*demands, admitted) * weight(int(p))
+		if w.Requests <= share {
+			return 0
+		}
+
+		return clamp(0, 1-share/w.Requests, 1)
+	}
+}
+
+// adaptiveProbability returns the rejection probability for the given number
+// of requests and accepts.
+func adaptiveProbability(requests, accepts, k, minPerWindow float64) float64 {
+	if requests+minPerWindow == 0 {
+		return 0
+	}
+
+	return clamp(0, (requests-k*accepts)/(requests+minPerWindow), 1)
+}
+
+// validWeight returns whether w can be used as the weight of a priority.
+func validWeight(w float64) bool {
+	return w > 0 && !math.IsInf(w, 1)
+}
@@ -1,5 +1,10 @@
 package bulwark
 
+import (
+	"fmt"
+	"math"
+)
+
 // StandardPriorities is the number of priority levels that are available.
 // This value should be used when creating a new AdaptiveThrottle when the
 // default Priority constants are used.
@@ -11,6 +16,10 @@ package bulwark
 //	 }
 const StandardPriorities = 4
 
+// maxPriorities is the largest number of priorities that can be represented by
+// the Priority type.
+const maxPriorities = math.MaxInt8 + 1
+
 // Priority determines the importance of a request in ascending order.
 // e.g. priority 0 is more important than priority 1.
 //
@@ -32,3 +41,62 @@ const (
 	// later when the system has spare capacity.
 	Low Priority = 3
 )
+
+// InvalidPriorityPolicy resolves a priority outside of `[0, priorities)` given
+// to a throttle. It returns either a valid priority to use instead, or an error
+// to reject the request locally. A priority returned out of range is clamped to
+// the nearest valid priority.
+//
+// Priorities often come from upstream services via PriorityFromContext, so a
+// throttle should not trust them blindly.
+type InvalidPriorityPolicy func(p Priority, priorities int) (Priority, error)
+
+var (
+	// ClampInvalidPriority replaces an invalid priority with the nearest valid
+	// priority. This is the default policy.
+	ClampInvalidPriority InvalidPriorityPolicy = func(p Priority, priorities int) (Priority, error) {
+		return clampPriority(p, priorities), nil
+	}
+	// RejectInvalidPriority rejects requests with an invalid priority with an
+	// InvalidPriorityError.
+	RejectInvalidPriority InvalidPriorityPolicy = func(p Priority, priorities int) (Priority, error) {
+		return p, &InvalidPriorityError{Priority: p, Priorities: priorities}
+	}
+	// PanicOnInvalidPriority panics with an InvalidPriorityError when a request
+	// has an invalid priority. It should only be used when priorities are fully
+	// controlled by the application.
+	PanicOnInvalidPriority InvalidPriorityPolicy = func(p Priority, priorities int) (Priority, error) {
+		panic(&InvalidPriorityError{Priority: p, Priorities: priorities})
+	}
+)
+
+// MapInvalidPriority replaces an invalid priority with the one returned by fn.
+// The result of fn is clamped to the nearest valid priority.
+func MapInvalidPriority(fn func(p Priority) Priority) InvalidPriorityPolicy {
+	return func(p Priority, priorities int) (Priority, error) {
+		return clampPriority(fn(p), priorities), nil
+	}
+}
+
+// InvalidPriorityError is returned when a request has a priority outside of
+// `[0, Priorities)` and the throttle uses RejectInvalidPriority.
+type InvalidPriorityError struct {
+	Priority   Priority
+	Priorities int
+}
+
+func (err *InvalidPriorityError) Error() string {
+	return fmt.Sprintf("bulwark: invalid priority %d, expected a value in [0, %d)", err.Priority, err.Priorities)
+}
+
+// clampPriority clamps p to the range [0, priorities).
+func clampPriority(p Priority, priorities int) Priority {
+	if p < 0 {
+		return 0
+	}
+	if int(p) >= priorities {
+		return Priority(priorities - 1)
+	}
+
+	return p
+}
@@ -0,0 +1,231 @@
+package bulwark
+
+import (
+	"context"
+	"slices"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultQueueTarget is the default queueing delay that CoDel tolerates.
+	DefaultQueueTarget = 5 * time.Millisecond
+	// DefaultQueueInterval is the default interval over which CoDel measures
+	// the queueing delay. It is also the longest time a request waits.
+	DefaultQueueInterval = 100 * time.Millisecond
+)
+
+// WithAdaptiveThrottleQueue lets the requests that the throttle would reject wait in a bounded
+// priority queue instead, for up to interval. A waiting request is sent when a request that
+// reached the backend completes without being rejected by it, so the queue does not increase
+// the load of the backend. A released request is still subject to its deadline and to the rate
+// limiter of the throttle, if any. Higher-priority requests are released first, and a
+// higher-priority request takes the place of the lowest-priority one when the queue is full.
+//
+// The queue is managed with CoDel (controlled delay): when the requests have waited for longer
+// than target over a whole interval, the queue is considered standing, and the requests only
+// wait for up to target until the delay drops again. The delay is measured with the clock of the
+// throttle when requests are queued, released, or time out. The requests are rejected with
+// ClientSideRejectionError when they are not released in time, or with the error of their
+// context when it is done first.
+//
+// When priorities are given, only the requests of these priorities are queued. By default, no
+// request is queued and rejections are immediate. See DefaultQueueTarget and
+// DefaultQueueInterval.
+//
+// This option is ignored by Reconfigure.
+//
+//	bulwark.WithAdaptiveThrottleQueue(100, bulwark.DefaultQueueTarget, bulwark.DefaultQueueInterval, bulwark.Low)
+func WithAdaptiveThrottleQueue(
+	size int, target, interval time.Duration, priorities ...Priority,
+) AdaptiveThrottleOption {
+	return AdaptiveThrottleOption{func(opts *adaptiveThrottleOptions) {
+		opts.queue = newAdmissionQueue(size, target, interval, priorities)
+	}}
+}
+
+// admissionQueue holds the requests waiting to be admitted, ordered by
+// priority and then by arrival.
+//
+// It is safe to use an admissionQueue concurrently.
+type admissionQueue struct {
+	size       int
+	target     time.Duration
+	interval   time.Duration
+	priorities []Priority
+	now        func() time.Time
+	// after returns a channel receiving the time once d elapsed, and a
+	// function stopping the timer.
+	after func(d time.Duration) (<-chan time.Time, func() bool)
+
+	m       sync.Mutex
+	waiters []*queueWaiter
+
+	// The state of CoDel: the minimum delay of the requests observed in the
+	// current interval, and whether the queue was standing in the previous
+	// one.
+	intervalStart time.Time
+	minDelay      time.Duration
+	overloaded    bool
+}
+
+// queueWaiter is a request waiting in an admissionQueue.
+type queueWaiter struct {
+	priority Priority
+	enqueued time.Time
+	// admitted receives whether the request is admitted once it is removed
+	// from the queue by another goroutine.
+	admitted chan bool
+}
+
+func newAdmissionQueue(size int, target, interval time.Duration, priorities []Priority) *admissionQueue {
+	return &admissionQueue{
+		size:       size,
+		target:     target,
+		interval:   interval,
+		priorities: priorities,
+		now:        time.Now,
+		after: func(d time.Duration) (<-chan time.Time, func() bool) {
+			timer := time.NewTimer(d)
+
+			return timer.C, timer.Stop
+		},
+	}
+}
+
+// wait waits for a request of the given priority to be released. It returns
+// nil when the request may be sent, or the error to return to the caller.
+func (q *admissionQueue) wait(ctx context.Context, p Priority) error {
+	if len(q.priorities) > 0 && !slices.Contains(q.priorities, p) {
+		return ClientSideRejectionError
+	}
+
+	q.m.Lock()
+	now := q.now()
+	w := &queueWaiter{priority: p, enqueued: now, admitted: make(chan bool, 1)}
+	if q.size <= 0 {
+		q.m.Unlock()
+
+		return ClientSideRejectionError
+	}
+	if len(q.waiters) >= q.size {
+		last := q.waiters[len(q.waiters)-1]
+		if last.priority <= p {
+			q.m.Unlock()
+
+			return ClientSideRejectionError
+		}
+		// Make room for the request of a higher priority
+		q.waiters = q.waiters[:len(q.waiters)-1]
+		last.admitted <- false
+	}
+	i, _ := slices.BinarySearchFunc(q.waiters, p, func(w *queueWaiter, p Priority) int {
+		if w.priority <= p {
+			return -1
+		}
+
+		return 1
+	})
+	// The request at the head of the queue tells how long the requests wait
+	var delay time.Duration
+	if len(q.waiters) > 0 {
+		delay = now.Sub(q.waiters[0].enqueued)
+	}
+	q.observe(now, delay)
+	q.waiters = slices.Insert(q.waiters, i, w)
+	timeout := q.interval
+	if q.overloaded {
+		timeout = q.target
+	}
+	q.m.Unlock()
+
+	expired, stop := q.after(timeout)
+	defer stop()
+
+	var err error
+	var timedOut bool
+	select {
+	case admitted := <-w.admitted:
+		if admitted {
+			return nil
+		}
+
+		return ClientSideRejectionError
+	case <-expired:
+		err = ClientSideRejectionError
+		timedOut = true
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	if q.remove(w, timedOut) {
+		return err
+	}
+
+	// The request was removed by another goroutine in the meantime
+	if admitted := <-w.admitted; admitted {
+		if ctx.Err() != nil {
+			// Let another request take its turn
+			q.release()
+
+			return ctx.Err()
+		}
+
+		return nil
+	}
+
+	return ClientSideRejectionError
+}
+
+// remove removes the given waiter from the queue. It returns false when it
+// is no longer queued. The delay of a waiter that timed out is observed, as
+// it waited for as long as it could.
+func (q *admissionQueue) remove(w *queueWaiter, timedOut bool) bool {
+	q.m.Lock()
+	defer q.m.Unlock()
+
+	i := slices.Index(q.waiters, w)
+	if i < 0 {
+		return false
+	}
+	q.waiters = slices.Delete(q.waiters, i, i+1)
+	if timedOut {
+		now := q.now()
+		q.observe(now, now.Sub(w.enqueued))
+	}
+
+	return true
+}
+
+// release admits the queued request with the highest priority, if any.
+func (q *admissionQueue) release() {
+	q.m.Lock()
+	defer q.m.Unlock()
+
+	if len(q.waiters) == 0 {
+		return
+	}
+	w := q.waiters[0]
+	q.waiters = slices.Delete(q.waiters, 0, 1)
+
+	now := q.now()
+	q.observe(now, now.Sub(w.enqueued))
+	w.admitted <- true
+}
+
+// observe records the delay of a request. When the interval is over, the
+// queue is considered standing for the next one if no request waited for
+// less than target. q.m must be held.
+func (q *admissionQueue) observe(now time.Time, delay time.Duration) {
+	elapsed := now.Sub(q.intervalStart)
+	if elapsed < q.interval {
+		q.minDelay = min(q.minDelay, delay)
+
+		return
+	}
+
+	// Without any request in the previous interval, the queue was not
+	// standing
+	q.overloaded = !q.intervalStart.IsZero() && elapsed < 2*q.interval && q.minDelay >= q.target
+	q.intervalStart = now
+	q.minDelay = delay
+}
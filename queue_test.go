@@ -0,0 +1,232 @@
+package bulwark
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/deixis/faults"
+)
+
+// fakeTimers replaces the timers of an admissionQueue. A timer is created once
+// its request is queued, so receiving it also tells that the request waits.
+type fakeTimers chan fakeTimer
+
+type fakeTimer struct {
+	d       time.Duration
+	expired chan time.Time
+}
+
+func (timers fakeTimers) after(d time.Duration) (<-chan time.Time, func() bool) {
+	timer := fakeTimer{d: d, expired: make(chan time.Time, 1)}
+	timers <- timer
+
+	return timer.expired, func() bool { return true }
+}
+
+func TestQueue(t *testing.T) {
+	var m sync.Mutex
+	now := time.Now()
+	clock := func() time.Time {
+		m.Lock()
+		defer m.Unlock()
+
+		return now
+	}
+	var random atomic.Value
+	random.Store(0.0)
+	throttle := NewAdaptiveThrottle(
+		StandardPriorities,
+		WithAdaptiveThrottleQueue(10, time.Millisecond, time.Minute, Low),
+		WithAdaptiveThrottleRandom(func() float64 { return random.Load().(float64) }),
+		WithAdaptiveThrottleClock(clock),
+	)
+	timers := make(fakeTimers, 1)
+	throttle.queue.after = timers.after
+	for i := 0; i < 100; i++ {
+		_ = throttle.Throttle(context.Background(), High, func(ctx context.Context) error {
+			return faults.Unavailable(0)
+		})
+	}
+
+	// A request reaches the backend
+	random.Store(1.0)
+	started, release := make(chan struct{}), make(chan struct{})
+	go func() {
+		_ = throttle.Throttle(context.Background(), High, func(ctx context.Context) error {
+			close(started)
+			<-release
+
+			return nil
+		})
+	}()
+	<-started
+	random.Store(0.0)
+
+	err := throttle.Throttle(context.Background(), Medium, func(ctx context.Context) error {
+		return nil
+	})
+	if !errors.Is(err, ClientSideRejectionError) {
+		t.Errorf("expected a priority without queue to be rejected immediately, got %v", err)
+	}
+
+	// The queued request is sent once the first one completes
+	var called atomic.Bool
+	done := make(chan error)
+	send := func(ctx context.Context) {
+		go func() {
+			done <- throttle.Throttle(ctx, Low, func(ctx context.Context) error {
+				called.Store(true)
+
+				return nil
+			})
+		}()
+	}
+	send(context.Background())
+	if timer := <-timers; timer.d != time.Minute || called.Load() {
+		t.Fatalf("expected the request to wait for up to the interval, got %s", timer.d)
+	}
+	close(release)
+	if err := <-done; err != nil || !called.Load() {
+		t.Errorf("expected the queued request to be sent, got %v", err)
+	}
+
+	// The context of a queued request is honoured
+	ctx, cancel := context.WithCancel(context.Background())
+	send(ctx)
+	<-timers
+	cancel()
+	if err := <-done; !errors.Is(err, context.Canceled) {
+		t.Errorf("expected the context error, got %v", err)
+	}
+
+	// A request does not wait for longer than the interval
+	send(context.Background())
+	timer := <-timers
+	m.Lock()
+	now = now.Add(time.Minute)
+	m.Unlock()
+	timer.expired <- now
+	if err := <-done; !errors.Is(err, ClientSideRejectionError) {
+		t.Errorf("expected the request to be rejected after the interval, got %v", err)
+	}
+}
+
+func TestQueueCoDel(t *testing.T) {
+	start := time.Now()
+	now := start
+	q := newAdmissionQueue(10, 5*time.Millisecond, 100*time.Millisecond, nil)
+	q.now = func() time.Time { return now }
+	timers := make(fakeTimers, 1)
+	q.after = timers.after
+
+	// wait queues a request at the given time, and returns its timer.
+	results := make(chan error, 10)
+	wait := func(at time.Duration) fakeTimer {
+		now = start.Add(at)
+		go func() { results <- q.wait(context.Background(), Low) }()
+
+		return <-timers
+	}
+	// expire makes a request time out at the given time.
+	expire := func(timer fakeTimer, at time.Duration) {
+		now = start.Add(at)
+		timer.expired <- now
+		if err := <-results; !errors.Is(err, ClientSideRejectionError) {
+			t.Fatalf("expected the request to time out, got %v", err)
+		}
+	}
+	release := func(at time.Duration) {
+		now = start.Add(at)
+		q.release()
+		if err := <-results; err != nil {
+			t.Fatalf("expected the request to be released, got %v", err)
+		}
+	}
+
+	a := wait(0)
+	b := wait(50 * time.Millisecond)
+	expire(a, 100*time.Millisecond)
+	c := wait(150 * time.Millisecond)
+	expire(b, 150*time.Millisecond)
+	if c.d != 100*time.Millisecond {
+		t.Errorf("expected the request to wait for up to the interval, got %s", c.d)
+	}
+
+	// Every request waited for longer than the target over the last interval,
+	// including the ones that timed out.
+	d := wait(200 * time.Millisecond)
+	if d.d != 5*time.Millisecond {
+		t.Errorf("expected the request to wait for up to the target once the queue is standing, got %s", d.d)
+	}
+
+	// The queue drains, so the delay drops again.
+	release(200 * time.Millisecond)
+	release(200 * time.Millisecond)
+	e := wait(300 * time.Millisecond)
+	if e.d != 100*time.Millisecond {
+		t.Errorf("expected the request to wait for up to the interval once the queue drained, got %s", e.d)
+	}
+	expire(e, 400*time.Millisecond)
+}
+
+func TestQueueRateLimiter(t *testing.T) {
+	now := time.Now()
+	clock := func() time.Time { return now }
+	var random atomic.Value
+	random.Store(1.0)
+	throttle := NewAdaptiveThrottle(
+		StandardPriorities,
+		WithAdaptiveThrottleRegistry(NewRegistry()),
+		WithAdaptiveThrottleQueue(10, time.Millisecond, time.Minute, Low),
+		WithAdaptiveThrottleRateLimiter(NewRateLimiter(
+			StandardPriorities, 0, 1,
+			WithRateLimiterReserve(0),
+			WithRateLimiterClock(clock),
+		)),
+		WithAdaptiveThrottleRandom(func() float64 { return random.Load().(float64) }),
+		WithAdaptiveThrottleClock(clock),
+	)
+	timers := make(fakeTimers, 1)
+	throttle.queue.after = timers.after
+	for i := 0; i < 100; i++ {
+		throttle.reject(High, "", now)
+	}
+
+	// The only token of the limiter is taken by a request in flight
+	started, release := make(chan struct{}), make(chan struct{})
+	go func() {
+		_ = throttle.Throttle(context.Background(), High, func(ctx context.Context) error {
+			close(started)
+			<-release
+
+			return nil
+		})
+	}()
+	<-started
+	random.Store(0.0)
+
+	done := make(chan error, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			done <- throttle.Throttle(context.Background(), Low, func(ctx context.Context) error {
+				t.Error("expected the queued request not to be sent")
+
+				return nil
+			})
+		}()
+		<-timers
+	}
+
+	// Both requests are released in turn, as the first one gives its place
+	// back when the limiter rejects it.
+	close(release)
+	for i := 0; i < 2; i++ {
+		if err := <-done; !errors.Is(err, RateLimitRejectionError) {
+			t.Errorf("expected the queued request to be rate limited, got %v", err)
+		}
+	}
+}
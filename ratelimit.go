@@ -0,0 +1,215 @@
+package bulwark
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+)
+
+// DefaultRateLimiterReserve is the default share of the burst of a
+// RateLimiter reserved for the requests of higher priorities.
+const DefaultRateLimiterReserve = 0.1
+
+// RateLimitRejectionError is the error returned when the client rejects the
+// request because it exceeds the rate of a RateLimiter. It wraps
+// ClientSideRejectionError, so errors.Is(err, ClientSideRejectionError) is
+// true, but the errors returned by the backend never match it.
+var RateLimitRejectionError = fmt.Errorf("bulwark: rate limit exceeded: %w", ClientSideRejectionError)
+
+// RateLimiter limits the rate of requests with a token bucket, like
+// golang.org/x/time/rate, with the same priorities as the AdaptiveThrottle.
+//
+// A share of the bucket is reserved for higher priorities: the requests of the
+// highest priority may use every token, while the requests of lower
+// priorities leave a growing number of tokens in the bucket. When the rate is
+// exceeded, lower-priority requests are therefore rejected first.
+//
+// It is safe to use a RateLimiter concurrently.
+type RateLimiter struct {
+	priorities int
+	rate       float64
+	burst      float64
+	reserve    float64
+	now        func() time.Time
+
+	m      sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+// NewRateLimiter returns a RateLimiter that allows rate requests per second on
+// average, and bursts of up to burst requests. The bucket starts full.
+//
+// priorities is the number of priorities that the limiter will accept. A
+// priority outside of `[0, priorities)` is clamped to the nearest valid
+// priority.
+func NewRateLimiter(priorities int, rate, burst float64, options ...RateLimiterOption) *RateLimiter {
+	opts := rateLimiterOptions{
+		reserve: DefaultRateLimiterReserve,
+		now: func() time.Time {
+			return Now()
+		},
+	}
+	for _, option := range options {
+		option.f(&opts)
+	}
+
+	return &RateLimiter{
+		priorities: priorities,
+		rate:       rate,
+		burst:      burst,
+		reserve:    opts.reserve,
+		now:        opts.now,
+		tokens:     burst,
+		last:       opts.now(),
+	}
+}
+
+// Additional options for the RateLimiter type.
+type RateLimiterOption struct {
+	f func(*rateLimiterOptions)
+}
+
+type rateLimiterOptions struct {
+	reserve float64
+	now     func() time.Time
+}
+
+// WithRateLimiterReserve sets the share, in `[0, 1]`, of the burst reserved
+// for higher priorities. The requests of the lowest priority leave this share
+// of the burst in the bucket, and the share left by the other priorities
+// decreases linearly up to the highest one. It defaults to
+// DefaultRateLimiterReserve.
+func WithRateLimiterReserve(share float64) RateLimiterOption {
+	return RateLimiterOption{func(opts *rateLimiterOptions) {
+		opts.reserve = share
+	}}
+}
+
+// WithRateLimiterClock sets the function used by the limiter to get the
+// current time. It defaults to the global Now function.
+func WithRateLimiterClock(now func() time.Time) RateLimiterOption {
+	return RateLimiterOption{func(opts *rateLimiterOptions) {
+		opts.now = now
+	}}
+}
+
+// Allow reports whether a request may be sent now. When it returns true, a
+// token is taken from the bucket.
+//
+// The default priority is used when the given `ctx` does not have a priority
+// set.
+func (l *RateLimiter) Allow(ctx context.Context, defaultPriority Priority) bool {
+	return l.allow(l.priority(ctx, defaultPriority))
+}
+
+// Wait waits until a request may be sent, and takes a token from the bucket.
+// It returns the error of `ctx` when it is done first, and
+// RateLimitRejectionError without waiting when the deadline of `ctx` would be
+// exceeded.
+//
+// The default priority is used when the given `ctx` does not have a priority
+// set.
+func (l *RateLimiter) Wait(ctx context.Context, defaultPriority Priority) error {
+	p := l.priority(ctx, defaultPriority)
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		now := l.now()
+		d, ok := l.take(p, now)
+		if ok {
+			return nil
+		}
+		if deadline, ok := ctx.Deadline(); ok && deadline.Sub(now) < d {
+			return RateLimitRejectionError
+		}
+
+		timer := time.NewTimer(d)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+
+			return ctx.Err()
+		}
+	}
+}
+
+// Throttle sends a request when the limiter allows it. When the request is
+// rejected locally with RateLimitRejectionError or `throttledFn` returns an
+// error, the fallback functions are called in order with the error returned
+// by the previous one, until one of them returns nil.
+//
+// The default priority is used when the given `ctx` does not have a priority
+// set.
+func (l *RateLimiter) Throttle(
+	ctx context.Context, defaultPriority Priority, fn throttledFn, fallbackFn ...fallbackFn,
+) error {
+	if !l.Allow(ctx, defaultPriority) {
+		return fallback(ctx, RateLimitRejectionError, true, fallbackFn)
+	}
+	if err := fn(ctx); err != nil {
+		return fallback(ctx, err, false, fallbackFn)
+	}
+
+	return nil
+}
+
+// WithAdaptiveThrottleRateLimiter limits the rate of the requests admitted by the throttle with
+// the given limiter, so a request must pass both. The requests rejected by the limiter fail with
+// RateLimitRejectionError, and they do not raise the rejection probability of the throttle. The
+// limiter only takes a token for the requests that the throttle admits.
+//
+// This option is ignored by Reconfigure.
+func WithAdaptiveThrottleRateLimiter(l *RateLimiter) AdaptiveThrottleOption {
+	return AdaptiveThrottleOption{func(opts *adaptiveThrottleOptions) {
+		opts.limiter = l
+	}}
+}
+
+// priority returns the priority of a request, clamped to the valid range.
+func (l *RateLimiter) priority(ctx context.Context, defaultPriority Priority) Priority {
+	return clampPriority(PriorityFromContext(ctx, defaultPriority), l.priorities)
+}
+
+// allow takes a token for a request of the given priority, if it may be sent
+// now.
+func (l *RateLimiter) allow(p Priority) bool {
+	_, ok := l.take(p, l.now())
+
+	return ok
+}
+
+// take takes a token for a request of the given priority. When the request
+// may not be sent yet, it returns how long to wait until it may.
+func (l *RateLimiter) take(p Priority, now time.Time) (time.Duration, bool) {
+	l.m.Lock()
+	defer l.m.Unlock()
+
+	if elapsed := now.Sub(l.last); elapsed > 0 {
+		l.tokens = min(l.tokens+elapsed.Seconds()*l.rate, l.burst)
+		l.last = now
+	}
+
+	// The tokens left in the bucket for higher priorities, capped so that
+	// every priority can be admitted with a full bucket.
+	var reserved float64
+	if l.priorities > 1 {
+		reserved = l.burst * l.reserve * float64(p) / float64(l.priorities-1)
+	}
+	reserved = clamp(0, reserved, max(l.burst-1, 0))
+
+	if l.tokens-1 >= reserved {
+		l.tokens--
+
+		return 0, true
+	}
+	if l.rate <= 0 {
+		return math.MaxInt64, false
+	}
+
+	return time.Duration((reserved + 1 - l.tokens) / l.rate * float64(time.Second)), false
+}
@@ -0,0 +1,135 @@
+package bulwark_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/deixis/bulwark"
+	"github.com/deixis/faults"
+)
+
+func TestRateLimiter(t *testing.T) {
+	now := time.Now()
+	limiter := bulwark.NewRateLimiter(
+		bulwark.StandardPriorities, 10, 10,
+		bulwark.WithRateLimiterReserve(0.5),
+		bulwark.WithRateLimiterClock(func() time.Time { return now }),
+	)
+	allowed := func(p bulwark.Priority) (n int) {
+		for limiter.Allow(context.Background(), p) {
+			n++
+		}
+
+		return n
+	}
+
+	// Low requests leave half of the burst to higher priorities
+	if n := allowed(bulwark.Low); n != 5 {
+		t.Errorf("expected 5 low requests to be allowed, got %d", n)
+	}
+	if n := allowed(bulwark.High); n != 5 {
+		t.Errorf("expected 5 high requests to be allowed, got %d", n)
+	}
+	now = now.Add(time.Second)
+	// The priority of the context takes precedence
+	ctx := bulwark.WithPriority(context.Background(), bulwark.High)
+	n := 0
+	for limiter.Allow(ctx, bulwark.Low) {
+		n++
+	}
+	if n != 10 {
+		t.Errorf("expected the bucket to be refilled, got %d", n)
+	}
+}
+
+func TestRateLimiterWait(t *testing.T) {
+	limiter := bulwark.NewRateLimiter(bulwark.StandardPriorities, 100, 1)
+	for i := 0; i < 2; i++ {
+		if err := limiter.Wait(context.Background(), bulwark.High); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+	}
+
+	limiter = bulwark.NewRateLimiter(bulwark.StandardPriorities, 1, 1)
+	_ = limiter.Allow(context.Background(), bulwark.High)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := limiter.Wait(ctx, bulwark.High); !errors.Is(err, bulwark.RateLimitRejectionError) {
+		t.Errorf("expected a rate limit rejection before the deadline, got %v", err)
+	}
+	ctx, cancel = context.WithCancel(context.Background())
+	cancel()
+	if err := limiter.Wait(ctx, bulwark.High); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected the context error, got %v", err)
+	}
+}
+
+func TestAdaptiveThrottleRateLimiter(t *testing.T) {
+	now := time.Now()
+	clock := func() time.Time { return now }
+	throttle := bulwark.NewAdaptiveThrottle(
+		bulwark.StandardPriorities,
+		bulwark.WithAdaptiveThrottleRateLimiter(bulwark.NewRateLimiter(
+			bulwark.StandardPriorities, 1, 10, bulwark.WithRateLimiterClock(clock),
+		)),
+		bulwark.WithAdaptiveThrottleClock(clock),
+	)
+
+	var sent, limited int
+	for i := 0; i < 20; i++ {
+		err := throttle.Throttle(context.Background(), bulwark.High, func(ctx context.Context) error {
+			sent++
+
+			return nil
+		})
+		if errors.Is(err, bulwark.RateLimitRejectionError) {
+			limited++
+		}
+	}
+	if sent != 10 || limited != 10 {
+		t.Errorf("expected 10 requests to be sent and 10 to be limited, got %d and %d", sent, limited)
+	}
+	if p := throttle.Stats().Priorities[bulwark.High]; p.Requests != 10 || p.Rejections != 0 {
+		t.Errorf("expected rate limited requests not to be counted, got %+v", p)
+	}
+}
+
+func TestRateLimitRejectionError(t *testing.T) {
+	if !errors.Is(bulwark.RateLimitRejectionError, bulwark.ClientSideRejectionError) {
+		t.Error("expected a rate limit rejection to be a client-side rejection")
+	}
+	for _, err := range []error{faults.ResourceExhausted(), faults.Unavailable(0)} {
+		if errors.Is(err, bulwark.RateLimitRejectionError) {
+			t.Errorf("expected an error of the backend not to be a rate limit rejection, got %v", err)
+		}
+	}
+}
+
+func TestForceAdmitRateLimiter(t *testing.T) {
+	now := time.Now()
+	clock := func() time.Time { return now }
+	throttle := bulwark.NewAdaptiveThrottle(
+		bulwark.StandardPriorities,
+		bulwark.WithAdaptiveThrottleRateLimiter(bulwark.NewRateLimiter(
+			bulwark.StandardPriorities, 0, 1, bulwark.WithRateLimiterClock(clock),
+		)),
+		bulwark.WithAdaptiveThrottleClock(clock),
+	)
+	throttle.ForceAdmit(0)
+
+	send := func(ctx context.Context) error {
+		return throttle.Throttle(ctx, bulwark.High, func(ctx context.Context) error {
+			now = now.Add(100 * time.Millisecond)
+
+			return nil
+		})
+	}
+	if err := send(context.Background()); err != nil {
+		t.Fatalf("expected the request to be admitted, got %v", err)
+	}
+	if err := send(context.Background()); !errors.Is(err, bulwark.RateLimitRejectionError) {
+		t.Errorf("expected a forced admission to honour the rate limit, got %v", err)
+	}
+}
@@ -0,0 +1,237 @@
+package bulwark
+
+import (
+	"slices"
+	"sort"
+	"sync"
+	"time"
+)
+
+// DefaultRegistry is the registry in which throttles created with
+// WithAdaptiveThrottleName are registered by default.
+var DefaultRegistry = NewRegistry()
+
+// Registry holds a set of adaptive throttles by name, so they can be looked up,
+// listed and inspected. Several throttles can be registered under the same
+// name, such as the throttles of several clients of the same backend.
+//
+// It is safe to use a Registry concurrently.
+type Registry struct {
+	m         sync.RWMutex
+	throttles map[string][]*AdaptiveThrottle
+}
+
+// NewRegistry returns an empty Registry.
+func NewRegistry() *Registry {
+	return &Registry{throttles: map[string][]*AdaptiveThrottle{}}
+}
+
+// Register adds the throttle to the registry under the given name, after the
+// throttles already registered under the same name. Registering a throttle
+// again under the same name has no effect.
+func (r *Registry) Register(name string, t *AdaptiveThrottle) {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	if !slices.Contains(r.throttles[name], t) {
+		r.throttles[name] = append(r.throttles[name], t)
+	}
+}
+
+// Unregister removes the throttle from the registry. The other throttles
+// registered under the same name are kept.
+func (r *Registry) Unregister(t *AdaptiveThrottle) {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	for name, throttles := range r.throttles {
+		throttles = slices.DeleteFunc(slices.Clone(throttles), func(registered *AdaptiveThrottle) bool {
+			return registered == t
+		})
+		if len(throttles) == 0 {
+			delete(r.throttles, name)
+		} else {
+			r.throttles[name] = throttles
+		}
+	}
+}
+
+// Get returns the throttle registered first under the given name.
+func (r *Registry) Get(name string) (*AdaptiveThrottle, bool) {
+	r.m.RLock()
+	defer r.m.RUnlock()
+
+	if throttles := r.throttles[name]; len(throttles) > 0 {
+		return throttles[0], true
+	}
+
+	return nil, false
+}
+
+// Names returns the names of all registered throttles in lexical order. A name
+// is returned once, even when several throttles are registered under it.
+func (r *Registry) Names() []string {
+	r.m.RLock()
+	names := make([]string, 0, len(r.throttles))
+	for name := range r.throttles {
+		names = append(names, name)
+	}
+	r.m.RUnlock()
+	sort.Strings(names)
+
+	return names
+}
+
+// Range calls fn for each registered throttle in lexical order of their names,
+// and in order of registration for the throttles with the same name. It stops
+// when fn returns false.
+func (r *Registry) Range(fn func(name string, t *AdaptiveThrottle) bool) {
+	type entry struct {
+		name string
+		t    *AdaptiveThrottle
+	}
+
+	r.m.RLock()
+	var entries []entry
+	for name, throttles := range r.throttles {
+		for _, t := range throttles {
+			entries = append(entries, entry{name: name, t: t})
+		}
+	}
+	r.m.RUnlock()
+	// The throttles of a name stay in order of registration
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].name < entries[j].name })
+
+	for _, e := range entries {
+		if !fn(e.name, e.t) {
+			return
+		}
+	}
+}
+
+// Stats returns the statistics of all registered throttles, in the order of
+// Range.
+func (r *Registry) Stats() []AdaptiveThrottleStats {
+	var stats []AdaptiveThrottleStats
+	r.Range(func(name string, t *AdaptiveThrottle) bool {
+		s := t.Stats()
+		s.Name = name
+		stats = append(stats, s)
+
+		return true
+	})
+
+	return stats
+}
+
+// AdaptiveThrottleStats is a snapshot of the state of an AdaptiveThrottle.
+type AdaptiveThrottleStats struct {
+	// Name is the name of the throttle, if any.
+	Name string `json:"name,omitempty"`
+	// Instance identifies the throttle among the throttles of the process,
+	// including the ones with the same name.
+	Instance uint64 `json:"instance"`
+	// Window is the time window over which requests are counted.
+	Window time.Duration `json:"window"`
+	// Ratio is the accept multiplier.
+	Ratio float64 `json:"ratio"`
+	// MinimumRate is the minimum number of requests per second sent to the
+	// backend.
+	MinimumRate float64 `json:"minimum_rate"`
+	// DryRun is true when the throttle does not enforce its decisions.
+	DryRun bool `json:"dry_run"`
+	// Override is the active manual override, if any.
+	Override *Override `json:"override,omitempty"`
+	// ExchangeError is the error of the last exchange of statistics with
+	// peers, if any. The throttle only uses its own counts until an exchange
+	// succeeds.
+	ExchangeError string `json:"exchange_error,omitempty"`
+	// Priorities holds the statistics of each priority, indexed by priority.
+	Priorities []PriorityStats `json:"priorities"`
+}
+
+// PriorityStats is a snapshot of the state of a single priority of an
+// AdaptiveThrottle.
+type PriorityStats struct {
+	Priority Priority `json:"priority"`
+	// Ratio is the accept multiplier of the priority.
+	Ratio float64 `json:"ratio"`
+	// MinimumRate is the minimum number of requests per second of the
+	// priority sent to the backend.
+	MinimumRate float64 `json:"minimum_rate"`
+	// Requests is the number of requests in the current window, including the
+	// ones rejected locally.
+	Requests int `json:"requests"`
+	// Accepts is the number of requests accepted by the backend in the current
+	// window.
+	Accepts int `json:"accepts"`
+	// Rejections is the number of requests rejected locally in the current
+	// window.
+	Rejections int `json:"rejections"`
+	// DryRunRejections is the number of requests that would have been
+	// rejected locally in the current window if the throttle was not in
+	// dry-run mode.
+	DryRunRejections int `json:"dry_run_rejections"`
+	// DeadlineRejections is the number of requests rejected locally in the
+	// current window because their deadline was shorter than the typical
+	// latency of the backend.
+	DeadlineRejections int `json:"deadline_rejections"`
+	// RejectionProbability is the probability that the next request is
+	// rejected locally.
+	RejectionProbability float64 `json:"rejection_probability"`
+	// RequestHistory is the number of requests in each bucket of the current
+	// window, from the oldest to the most recent one.
+	RequestHistory []int `json:"request_history"`
+	// AcceptHistory is the number of accepts in each bucket of the current
+	// window, from the oldest to the most recent one.
+	AcceptHistory []int `json:"accept_history"`
+}
+
+// Stats returns a snapshot of the state of the throttle.
+func (t *AdaptiveThrottle) Stats() AdaptiveThrottleStats {
+	now := t.now()
+
+	t.m.Lock()
+	stats := AdaptiveThrottleStats{
+		Name:        t.name,
+		Instance:    t.id,
+		Window:      t.d,
+		Ratio:       t.k,
+		MinimumRate: t.minRate,
+		DryRun:      t.dryRun.Load(),
+		Priorities:  make([]PriorityStats, len(t.requests)),
+	}
+	if o, ok := t.activeOverride(now); ok {
+		stats.Override = &o
+	}
+	for i := range stats.Priorities {
+		stats.Priorities[i] = PriorityStats{
+			Priority:    Priority(i),
+			Ratio:       t.ratio(Priority(i)),
+			MinimumRate: t.minimumRate(Priority(i)),
+			Requests:    t.requests[i].get(now),
+			Accepts:     t.accepts[i].get(now),
+
+			Rejections:         t.rejects[i].get(now),
+			DryRunRejections:   t.dryRunRejects[i].get(now),
+			DeadlineRejections: t.deadlineRejects[i].get(now),
+
+			RequestHistory: t.requests[i].history(now),
+			AcceptHistory:  t.accepts[i].history(now),
+		}
+	}
+	t.m.Unlock()
+
+	for i := range stats.Priorities {
+		stats.Priorities[i].RejectionProbability = t.rejectionProbability(Priority(i), now)
+	}
+
+	// The rejection probability exchanges the counts with peers, if needed
+	t.m.Lock()
+	if t.exchangeErr != nil {
+		stats.ExchangeError = t.exchangeErr.Error()
+	}
+	t.m.Unlock()
+
+	return stats
+}
@@ -0,0 +1,76 @@
+package bulwark_test
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/deixis/bulwark"
+	"github.com/deixis/faults"
+)
+
+func TestRegistry(t *testing.T) {
+	r := bulwark.NewRegistry()
+	payments := bulwark.NewAdaptiveThrottle(
+		bulwark.StandardPriorities,
+		bulwark.WithAdaptiveThrottleName("payments"),
+		bulwark.WithAdaptiveThrottleRegistry(r),
+	)
+	bulwark.NewAdaptiveThrottle(
+		bulwark.StandardPriorities,
+		bulwark.WithAdaptiveThrottleName("accounts"),
+		bulwark.WithAdaptiveThrottleRegistry(r),
+	)
+
+	if got, want := r.Names(), []string{"accounts", "payments"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("expected names %v, got %v", want, got)
+	}
+	if got, ok := r.Get("payments"); !ok || got != payments {
+		t.Errorf("expected to get the payments throttle, got %v", got)
+	}
+
+	ctx := context.Background()
+	_ = payments.Throttle(ctx, bulwark.High, func(ctx context.Context) error { return nil })
+	_ = payments.Throttle(ctx, bulwark.High, func(ctx context.Context) error { return faults.Unavailable(0) })
+
+	stats := r.Stats()
+	if len(stats) != 2 || stats[1].Name != "payments" {
+		t.Fatalf("expected stats for accounts and payments, got %+v", stats)
+	}
+	if got := stats[1].Priorities[bulwark.High]; got.Requests != 2 || got.Accepts != 1 {
+		t.Errorf("expected 2 requests and 1 accept, got %+v", got)
+	}
+
+	// A throttle with the same name does not replace the first one
+	other := bulwark.NewAdaptiveThrottle(
+		bulwark.StandardPriorities,
+		bulwark.WithAdaptiveThrottleName("payments"),
+		bulwark.WithAdaptiveThrottleRegistry(r),
+	)
+	var registered []*bulwark.AdaptiveThrottle
+	r.Range(func(name string, t *bulwark.AdaptiveThrottle) bool {
+		if name == "payments" {
+			registered = append(registered, t)
+		}
+
+		return true
+	})
+	if len(registered) != 2 || registered[0] != payments || registered[1] != other {
+		t.Errorf("expected both payments throttles in order of registration, got %v", registered)
+	}
+	if stats := r.Stats(); len(stats) != 3 || stats[1].Instance == stats[2].Instance {
+		t.Errorf("expected the stats of every throttle, got %+v", stats)
+	}
+
+	r.Unregister(payments)
+	if got, ok := r.Get("payments"); !ok || got != other {
+		t.Errorf("expected only the first payments throttle to be unregistered, got %v", got)
+	}
+	r.Unregister(other)
+	if _, ok := r.Get("payments"); ok {
+		t.Error("expected payments to be unregistered")
+	}
+	if got, want := r.Names(), []string{"accounts"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("expected names %v, got %v", want, got)
+	}
+}
@@ -0,0 +1,99 @@
+package bulwark
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+// RampFunc returns the fraction of the requests, in `[0, 1]`, that a throttle
+// admits at the given progress of a slow start, in `[0, 1)`.
+type RampFunc func(progress float64) float64
+
+// LinearRamp returns a ramp that admits the given fraction of the requests at
+// the beginning of the slow start, and grows linearly to all of them. start
+// must be in `[0, 1]`, or LinearRamp panics.
+func LinearRamp(start float64) RampFunc {
+	if !(start >= 0 && start <= 1) {
+		panic(fmt.Sprintf("bulwark: start of a linear ramp must be in [0, 1], got %v", start))
+	}
+
+	return func(progress float64) float64 {
+		return start + (1-start)*progress
+	}
+}
+
+// ExponentialRamp returns a ramp that admits the given fraction of the
+// requests at the beginning of the slow start, and grows exponentially to all
+// of them, like the slow start of TCP. start must be in `(0, 1]`, or
+// ExponentialRamp panics.
+func ExponentialRamp(start float64) RampFunc {
+	if !(start > 0 && start <= 1) {
+		panic(fmt.Sprintf("bulwark: start of an exponential ramp must be in (0, 1], got %v", start))
+	}
+
+	return func(progress float64) float64 {
+		return start * math.Pow(1/start, progress)
+	}
+}
+
+// WithAdaptiveThrottleSlowStart limits the fraction of the requests admitted by the throttle for
+// a period after a priority stops being shed, and after the throttle is created. Without a slow
+// start, the rejection probability drops as soon as the backend recovers, and all the traffic
+// floods back at once, which can overload a backend with cold caches again.
+//
+// The fraction of the requests admitted grows from the start of ramp to all of them over d. The
+// requests rejected by the slow start are not counted as requests, so they do not raise the
+// rejection probability. A duration that is not positive or a nil ramp disables the slow start.
+//
+//	bulwark.WithAdaptiveThrottleSlowStart(30*time.Second, bulwark.ExponentialRamp(0.05))
+func WithAdaptiveThrottleSlowStart(d time.Duration, ramp RampFunc) AdaptiveThrottleOption {
+	return AdaptiveThrottleOption{func(opts *adaptiveThrottleOptions) {
+		if d <= 0 || ramp == nil {
+			d, ramp = 0, nil
+		}
+		opts.slowStart = d
+		opts.ramp = ramp
+	}}
+}
+
+// rampRejectionProbability returns the probability that a request of the
+// given priority is rejected by the slow start, given the rejection
+// probability of the priority. A ramp starts when the rejection probability
+// returns to zero.
+func (t *AdaptiveThrottle) rampRejectionProbability(p Priority, probability float64, now time.Time) float64 {
+	t.m.Lock()
+	defer t.m.Unlock()
+
+	if t.slowStart <= 0 || t.ramp == nil {
+		return 0
+	}
+	if probability > 0 {
+		t.shedding[p] = true
+		t.ramps[p] = time.Time{}
+
+		return 0
+	}
+	if t.shedding[p] {
+		t.shedding[p] = false
+		t.ramps[p] = now
+	}
+	if t.ramps[p].IsZero() {
+		return 0
+	}
+
+	progress := float64(now.Sub(t.ramps[p])) / float64(t.slowStart)
+	if progress >= 1 {
+		t.ramps[p] = time.Time{}
+
+		return 0
+	}
+
+	admitted := t.ramp(max(progress, 0))
+	if math.IsNaN(admitted) {
+		// A ramp must not reject every request for the whole slow start
+		return 0
+	}
+
+	return clamp(0, 1-admitted, 1)
+}
@@ -0,0 +1,182 @@
+package bulwark
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+const (
+	// snapshotVersion is the version of the encoding of throttle snapshots.
+	snapshotVersion = 1
+	// snapshotCounters is the number of sets of counters in a snapshot. See
+	// AdaptiveThrottle.counters.
+	snapshotCounters = 5
+)
+
+// errInvalidSnapshot is returned when a snapshot cannot be decoded.
+var errInvalidSnapshot = errors.New("bulwark: invalid throttle snapshot")
+
+// MarshalBinary encodes the recent statistics of the throttle, so they can be
+// restored with UnmarshalBinary after a restart. The configuration, tenants,
+// override and latencies of the throttle are not included.
+//
+// It implements encoding.BinaryMarshaler.
+func (t *AdaptiveThrottle) MarshalBinary() ([]byte, error) {
+	now := t.now()
+
+	t.m.Lock()
+	defer t.m.Unlock()
+
+	b := binary.AppendUvarint(nil, snapshotVersion)
+	b = binary.AppendUvarint(b, uint64(len(t.requests)))
+	for _, counters := range t.counters() {
+		for i := range counters {
+			counters[i].get(now)
+			data, err := counters[i].MarshalBinary()
+			if err != nil {
+				return nil, err
+			}
+			b = binary.AppendUvarint(b, uint64(len(data)))
+			b = append(b, data...)
+		}
+	}
+
+	return b, nil
+}
+
+// UnmarshalBinary restores the statistics encoded by MarshalBinary, so a
+// restarting process resumes with the recent state of the backend rather than
+// sending it its full load. The time elapsed since the snapshot was taken is
+// accounted for: requests older than the window of the throttle are dropped.
+//
+// The snapshot must have been taken from a throttle with the same number of
+// priorities. Its window may differ, in which case the counts are
+// redistributed according to their age.
+//
+// It implements encoding.BinaryUnmarshaler.
+func (t *AdaptiveThrottle) UnmarshalBinary(data []byte) error {
+	r := snapshotReader{data: data}
+	v := r.uvarint()
+	if r.err == nil && v != snapshotVersion {
+		return fmt.Errorf("%w: unsupported version %d", errInvalidSnapshot, v)
+	}
+	priorities := r.uvarint()
+	if r.err == nil && priorities > maxPriorities {
+		r.fail("too many priorities")
+	}
+	if r.err != nil {
+		return r.err
+	}
+
+	sets := make([][]windowedCounter, snapshotCounters)
+	for i := range sets {
+		sets[i] = make([]windowedCounter, priorities)
+		for j := range sets[i] {
+			data := r.bytes()
+			if r.err != nil {
+				return r.err
+			}
+			if err := sets[i][j].UnmarshalBinary(data); err != nil {
+				return err
+			}
+		}
+	}
+	if err := r.close(); err != nil {
+		return err
+	}
+
+	now := t.now()
+
+	t.m.Lock()
+	defer t.m.Unlock()
+
+	if int(priorities) != len(t.requests) {
+		return fmt.Errorf(
+			"%w: expected %d priorities, got %d", errInvalidSnapshot, len(t.requests), priorities,
+		)
+	}
+	for _, counters := range sets {
+		for i := range counters {
+			counters[i].restore(now, t.d/windowBuckets)
+		}
+	}
+	t.requests, t.accepts, t.rejects, t.dryRunRejects, t.deadlineRejects = sets[0], sets[1], sets[2], sets[3], sets[4]
+
+	return nil
+}
+
+// counters returns the counters of the throttle that are included in
+// snapshots, in order. t.m must be held.
+func (t *AdaptiveThrottle) counters() [][]windowedCounter {
+	return [][]windowedCounter{t.requests, t.accepts, t.rejects, t.dryRunRejects, t.deadlineRejects}
+}
+
+// snapshotReader decodes the values of a snapshot. The first error is kept,
+// and every subsequent read returns a zero value.
+type snapshotReader struct {
+	data []byte
+	err  error
+}
+
+func (r *snapshotReader) uvarint() uint64 {
+	if r.err != nil {
+		return 0
+	}
+	x, n := binary.Uvarint(r.data)
+	if n <= 0 {
+		r.fail("truncated value")
+
+		return 0
+	}
+	r.data = r.data[n:]
+
+	return x
+}
+
+func (r *snapshotReader) varint() int64 {
+	if r.err != nil {
+		return 0
+	}
+	x, n := binary.Varint(r.data)
+	if n <= 0 {
+		r.fail("truncated value")
+
+		return 0
+	}
+	r.data = r.data[n:]
+
+	return x
+}
+
+// bytes reads a length-prefixed slice of bytes.
+func (r *snapshotReader) bytes() []byte {
+	n := r.uvarint()
+	if r.err != nil {
+		return nil
+	}
+	if n > uint64(len(r.data)) {
+		r.fail("truncated value")
+
+		return nil
+	}
+	b := r.data[:n]
+	r.data = r.data[n:]
+
+	return b
+}
+
+func (r *snapshotReader) fail(reason string) {
+	if r.err == nil {
+		r.err = fmt.Errorf("%w: %s", errInvalidSnapshot, reason)
+	}
+}
+
+// close returns the first error, or an error when there is data left.
+func (r *snapshotReader) close() error {
+	if r.err == nil && len(r.data) > 0 {
+		r.fail("trailing data")
+	}
+
+	return r.err
+}
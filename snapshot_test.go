@@ -0,0 +1,114 @@
+package bulwark
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestSnapshot(t *testing.T) {
+	now := time.Now()
+	clock := WithAdaptiveThrottleClock(func() time.Time { return now })
+
+	throttle := NewAdaptiveThrottle(StandardPriorities, WithAdaptiveThrottleWindow(10*time.Second), clock)
+	for i := 0; i < 10; i++ {
+		throttle.reject(High, "", now)
+		throttle.accept(Low, "", now)
+		now = now.Add(time.Second)
+	}
+	throttle.deadlineRejects[Medium].add(now, 2)
+	data, err := throttle.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The process restarts 3 seconds later
+	now = now.Add(3 * time.Second)
+	restored := NewAdaptiveThrottle(StandardPriorities, WithAdaptiveThrottleWindow(10*time.Second), clock)
+	if err := restored.UnmarshalBinary(data); err != nil {
+		t.Fatal(err)
+	}
+	if got := restored.requests[High].get(now); got != 6 {
+		t.Errorf("expected the 6 most recent requests to be restored, got %d", got)
+	}
+	if got := restored.accepts[Low].get(now); got != 6 {
+		t.Errorf("expected the 6 most recent accepts to be restored, got %d", got)
+	}
+	if got := restored.deadlineRejects[Medium].get(now); got != 2 {
+		t.Errorf("expected the deadline rejections to be restored, got %d", got)
+	}
+	if got, expect := restored.rejectionProbability(High, now), throttle.rejectionProbability(High, now); got != expect {
+		t.Errorf("expected a rejection probability of %v, got %v", expect, got)
+	}
+
+	// The window is shorter after the restart
+	shorter := NewAdaptiveThrottle(StandardPriorities, WithAdaptiveThrottleWindow(5*time.Second), clock)
+	if err := shorter.UnmarshalBinary(data); err != nil {
+		t.Fatal(err)
+	}
+	if got := shorter.requests[High].get(now); got != 1 {
+		t.Errorf("expected the request within the new window to be restored, got %d", got)
+	}
+
+	// The snapshot is too old
+	now = now.Add(time.Minute)
+	if err := restored.UnmarshalBinary(data); err != nil {
+		t.Fatal(err)
+	}
+	if got := restored.requests[High].get(now); got != 0 {
+		t.Errorf("expected expired requests to be dropped, got %d", got)
+	}
+
+	for name, data := range map[string][]byte{
+		"truncated":  data[:len(data)-1],
+		"version":    append([]byte{snapshotVersion + 1}, data[1:]...),
+		"priorities": mustMarshal(t, NewAdaptiveThrottle(2)),
+		"empty":      nil,
+	} {
+		if err := restored.UnmarshalBinary(data); !errors.Is(err, errInvalidSnapshot) {
+			t.Errorf("%s: expected an invalid snapshot error, got %v", name, err)
+		}
+	}
+}
+
+func mustMarshal(t *testing.T, throttle *AdaptiveThrottle) []byte {
+	t.Helper()
+
+	data, err := throttle.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return data
+}
+
+func TestSnapshotCounter(t *testing.T) {
+	now := time.Now()
+	valid := newWindowedCounter(now, time.Second, windowBuckets)
+	valid.add(now, 3)
+	buckets := newWindowedCounter(now, time.Second, windowBuckets+1)
+	negative := newWindowedCounter(now, time.Second, windowBuckets)
+	negative.add(now, -1)
+
+	for name, tt := range map[string]struct {
+		counter windowedCounter
+		valid   bool
+	}{
+		"valid":    {counter: valid, valid: true},
+		"buckets":  {counter: buckets},
+		"negative": {counter: negative},
+	} {
+		data, err := tt.counter.MarshalBinary()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var c windowedCounter
+		err = c.UnmarshalBinary(data)
+		switch {
+		case tt.valid && err != nil:
+			t.Errorf("%s: expected the counter to be decoded, got %v", name, err)
+		case !tt.valid && !errors.Is(err, errInvalidSnapshot):
+			t.Errorf("%s: expected an invalid snapshot error, got %v", name, err)
+		}
+	}
+}
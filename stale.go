@@ -0,0 +1,224 @@
+package bulwark
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// StaleCache holds the last successful value returned by throttled functions by
+// key, for a limited time. It is used with StaleCacheFallback or ThrottleStale
+// to serve a stale value rather than failing when the backend is overloaded.
+//
+// Values are shared between callers, so they should not be mutated.
+//
+// It is safe to use a StaleCache concurrently.
+type StaleCache[T any] struct {
+	ttl     time.Duration
+	maxSize int
+
+	m       sync.Mutex
+	seq     uint64
+	entries map[string]staleEntry[T]
+}
+
+type staleEntry[T any] struct {
+	value  T
+	stored time.Time
+	// seq orders the calls that produced the values, so a slow call does not
+	// overwrite the value of a more recent one.
+	seq uint64
+}
+
+// NewStaleCache returns a StaleCache that keeps values for ttl.
+func NewStaleCache[T any](ttl time.Duration, options ...StaleCacheOption) *StaleCache[T] {
+	opts := staleCacheOptions{}
+	for _, option := range options {
+		option.f(&opts)
+	}
+
+	return &StaleCache[T]{
+		ttl:     ttl,
+		maxSize: opts.maxSize,
+		entries: map[string]staleEntry[T]{},
+	}
+}
+
+// Additional options for the StaleCache type.
+type StaleCacheOption struct {
+	f func(*staleCacheOptions)
+}
+
+type staleCacheOptions struct {
+	maxSize int
+}
+
+// WithStaleCacheMaxSize sets the maximum number of keys held by the cache. When
+// the cache is full, the oldest value is evicted. The cache is unbounded by
+// default.
+func WithStaleCacheMaxSize(n int) StaleCacheOption {
+	return StaleCacheOption{func(opts *staleCacheOptions) {
+		opts.maxSize = n
+	}}
+}
+
+// Record returns a throttled function that calls fn and stores its result under
+// key when it succeeds. When calls for the same key overlap, the value of the
+// call that started last is kept.
+func (c *StaleCache[T]) Record(key string, fn throttledArgsFn[T]) throttledArgsFn[T] {
+	return func(ctx context.Context) (T, error) {
+		seq := c.next()
+		t, err := fn(ctx)
+		if err == nil {
+			c.set(key, t, seq)
+		}
+
+		return t, err
+	}
+}
+
+// Set stores v under key.
+func (c *StaleCache[T]) Set(key string, v T) {
+	c.set(key, v, c.next())
+}
+
+// Get returns the value stored under key, unless it has expired.
+func (c *StaleCache[T]) Get(key string) (T, bool) {
+	t, _, ok := c.get(key)
+
+	return t, ok
+}
+
+// next returns the sequence number of a new call.
+func (c *StaleCache[T]) next() uint64 {
+	c.m.Lock()
+	defer c.m.Unlock()
+	c.seq++
+
+	return c.seq
+}
+
+func (c *StaleCache[T]) set(key string, v T, seq uint64) {
+	now := Now()
+
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	if e, ok := c.entries[key]; ok && e.seq > seq {
+		// A more recent call already stored its value
+		return
+	}
+	c.entries[key] = staleEntry[T]{value: v, stored: now, seq: seq}
+	if c.maxSize > 0 && len(c.entries) > c.maxSize {
+		c.evict(now)
+	}
+}
+
+// get returns the value stored under key and its age, unless it has expired.
+func (c *StaleCache[T]) get(key string) (T, time.Duration, bool) {
+	now := Now()
+
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	e, ok := c.entries[key]
+	if !ok {
+		var zero T
+
+		return zero, 0, false
+	}
+	age := now.Sub(e.stored)
+	if age >= c.ttl {
+		delete(c.entries, key)
+		var zero T
+
+		return zero, 0, false
+	}
+
+	return e.value, age, true
+}
+
+// evict removes the expired entries, or the oldest entry when none has
+// expired. c.m must be held.
+func (c *StaleCache[T]) evict(now time.Time) {
+	var oldestKey string
+	var oldest *staleEntry[T]
+	for key, e := range c.entries {
+		if now.Sub(e.stored) >= c.ttl {
+			delete(c.entries, key)
+
+			continue
+		}
+		if oldest == nil || e.stored.Before(oldest.stored) {
+			oldestKey, oldest = key, &e
+		}
+	}
+	if len(c.entries) > c.maxSize {
+		delete(c.entries, oldestKey)
+	}
+}
+
+// StaleResult is the result of ThrottleStale.
+type StaleResult[T any] struct {
+	// Value is the value returned by the throttled function, or the last good
+	// value when Stale is true.
+	Value T
+	// Stale is true when Value was served from the cache.
+	Stale bool
+	// Age is the time since the stale value was stored.
+	Age time.Duration
+	// Err is the error that caused the stale value to be served.
+	Err error
+}
+
+// ThrottleStale is like Throttle, but serves the last good value stored in the
+// cache under key when the request is rejected locally, or when the backend
+// returns an error that indicates that it is unhealthy. Successful results are
+// stored in the cache.
+//
+// Other errors, such as bad requests, are returned as is, since a stale value
+// would hide them. When there is no stale value, the error is returned.
+//
+//	cache := bulwark.NewStaleCache[*Profile](time.Minute)
+//	res, err := bulwark.ThrottleStale(ctx, throttle, cache, userID, bulwark.Medium, fetchProfile)
+//	if err != nil {
+//		// handle the error
+//	}
+//	if res.Stale {
+//		// the profile may be up to a minute old
+//	}
+func ThrottleStale[T any](
+	ctx context.Context,
+	at *AdaptiveThrottle,
+	c *StaleCache[T],
+	key string,
+	defaultPriority Priority,
+	throttledFn throttledArgsFn[T],
+) (StaleResult[T], error) {
+	var rejected bool
+	fn := c.Record(key, func(ctx context.Context) (T, error) {
+		t, err := throttledFn(ctx)
+		rejected = err != nil && at.isRejection(err)
+
+		return t, err
+	})
+
+	var res StaleResult[T]
+	v, err := Throttle(ctx, at, defaultPriority, fn, func(ctx context.Context, err error, local bool) (T, error) {
+		if !local && !rejected {
+			var zero T
+
+			return zero, err
+		}
+		t, age, ok := c.get(key)
+		if !ok {
+			return t, err
+		}
+		res.Stale, res.Age, res.Err = true, age, err
+
+		return t, nil
+	})
+	res.Value = v
+
+	return res, err
+}
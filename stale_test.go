@@ -0,0 +1,96 @@
+package bulwark_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/deixis/bulwark"
+	"github.com/deixis/faults"
+)
+
+func TestThrottleStale(t *testing.T) {
+	ctx := context.Background()
+	// Never reject locally, so only the errors of the backend are involved
+	throttle := bulwark.NewAdaptiveThrottle(
+		bulwark.StandardPriorities,
+		bulwark.WithAdaptiveThrottleRandom(func() float64 { return 1 }),
+	)
+	cache := bulwark.NewStaleCache[string](time.Minute)
+	call := func(msg string, err error) (bulwark.StaleResult[string], error) {
+		return bulwark.ThrottleStale(ctx, throttle, cache, "key", bulwark.High, func(ctx context.Context) (string, error) {
+			return msg, err
+		})
+	}
+
+	if _, err := call("", faults.Unavailable(0)); !faults.IsUnavailable(err) {
+		t.Errorf("expected the error without a stale value, got %v", err)
+	}
+
+	res, err := call("fresh", nil)
+	if err != nil || res.Stale || res.Value != "fresh" {
+		t.Fatalf("expected a fresh value, got %+v, %v", res, err)
+	}
+
+	res, err = call("", faults.Unavailable(0))
+	if err != nil || !res.Stale || res.Value != "fresh" || !faults.IsUnavailable(res.Err) {
+		t.Errorf("expected the stale value for an overload error, got %+v, %v", res, err)
+	}
+
+	if _, err = call("", faults.Bad()); !faults.IsBad(err) {
+		t.Errorf("expected a bad request to be returned as is, got %v", err)
+	}
+}
+
+func TestStaleCacheOrdering(t *testing.T) {
+	ctx := context.Background()
+	cache := bulwark.NewStaleCache[string](time.Minute)
+
+	// The first call starts before the second one, but finishes after it.
+	started := make(chan struct{})
+	release := make(chan struct{})
+	done := make(chan struct{})
+	slow := cache.Record("key", func(ctx context.Context) (string, error) {
+		close(started)
+		<-release
+
+		return "old", nil
+	})
+	go func() {
+		defer close(done)
+		_, _ = slow(ctx)
+	}()
+	<-started
+
+	fast := cache.Record("key", func(ctx context.Context) (string, error) {
+		return "new", nil
+	})
+	_, _ = fast(ctx)
+	close(release)
+	<-done
+
+	if v, _ := cache.Get("key"); v != "new" {
+		t.Errorf("expected the value of the most recent call, got %q", v)
+	}
+}
+
+func TestStaleCacheMaxSize(t *testing.T) {
+	now := time.Now()
+	bulwark.Now = func() time.Time { return now }
+	defer func() { bulwark.Now = time.Now }()
+
+	cache := bulwark.NewStaleCache[int](time.Minute, bulwark.WithStaleCacheMaxSize(2))
+	for i, key := range []string{"a", "b", "c"} {
+		cache.Set(key, i)
+		now = now.Add(time.Second)
+	}
+
+	if _, ok := cache.Get("a"); ok {
+		t.Error("expected the oldest value to be evicted")
+	}
+	for _, key := range []string{"b", "c"} {
+		if _, ok := cache.Get(key); !ok {
+			t.Errorf("expected %q to be kept", key)
+		}
+	}
+}
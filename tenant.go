@@ -0,0 +1,209 @@
+package bulwark
+
+import (
+	"cmp"
+	"context"
+	"math"
+	"slices"
+	"time"
+)
+
+// maxTenants is the number of tenants tracked for each priority. When a new
+// tenant is seen once the limit is reached, the tenant with the fewest recent
+// requests is forgotten.
+const maxTenants = 1024
+
+type tenantKey struct{}
+
+var activeTenantKey = tenantKey{}
+
+// TenantFromContext returns the tenant attached to the context, or an empty
+// string when no tenant is attached.
+func TenantFromContext(ctx context.Context) string {
+	if tenant, ok := ctx.Value(activeTenantKey).(string); ok {
+		return tenant
+	}
+
+	return ""
+}
+
+// WithTenant attaches the given tenant to the context. Throttles created with
+// WithAdaptiveThrottleTenantFairness share the capacity of each priority
+// fairly between tenants.
+//
+// Requests without a tenant are attributed to a single anonymous tenant.
+func WithTenant(ctx context.Context, tenant string) context.Context {
+	return context.WithValue(ctx, activeTenantKey, tenant)
+}
+
+// WithAdaptiveThrottleTenantFairness enables or disables tenant fairness. When it is enabled, the
+// requests that the throttle admits for a priority are shared between the tenants attached to the
+// context with WithTenant, so that a tenant sending more than its fair share of the recent
+// requests is shed first, while tenants below their fair share are not throttled.
+//
+// The total number of requests admitted for a priority is unchanged. It is only distributed
+// differently between tenants.
+func WithAdaptiveThrottleTenantFairness(enabled bool) AdaptiveThrottleOption {
+	return AdaptiveThrottleOption{func(opts *adaptiveThrottleOptions) {
+		opts.tenantFairness = enabled
+	}}
+}
+
+// newTenants returns empty tenant counters for the given number of priorities.
+func newTenants(priorities int) []map[string]*windowedCounter {
+	tenants := make([]map[string]*windowedCounter, priorities)
+	for i := range tenants {
+		tenants[i] = map[string]*windowedCounter{}
+	}
+
+	return tenants
+}
+
+// countTenant records a request of the tenant for the given priority. t.m must
+// be held.
+func (t *AdaptiveThrottle) countTenant(p Priority, tenant string, now time.Time) {
+	if !t.tenantFairness {
+		return
+	}
+
+	// Forget the tenants that were not seen within the window, at most once
+	// per bucket
+	if now.Sub(t.tenantsPruned) >= t.d/windowBuckets {
+		t.pruneTenants(now)
+	}
+
+	tenants := t.tenants[int(p)]
+	c, ok := tenants[tenant]
+	if !ok {
+		if len(tenants) >= maxTenants {
+			forgetQuietestTenant(tenants, now)
+		}
+		counter := newWindowedCounter(now, t.d/windowBuckets, windowBuckets)
+		c = &counter
+		tenants[tenant] = c
+	}
+	c.add(now, 1)
+}
+
+// pruneTenants forgets the tenants without requests in the window. t.m must be
+// held.
+func (t *AdaptiveThrottle) pruneTenants(now time.Time) {
+	for _, tenants := range t.tenants {
+		for name, c := range tenants {
+			if c.get(now) == 0 {
+				delete(tenants, name)
+			}
+		}
+	}
+	t.tenantsPruned = now
+}
+
+// forgetQuietestTenant forgets the tenant with the fewest requests in the
+// window.
+func forgetQuietestTenant(tenants map[string]*windowedCounter, now time.Time) {
+	quietest, fewest := "", math.MaxInt
+	for name, c := range tenants {
+		if n := c.get(now); n < fewest {
+			quietest, fewest = name, n
+		}
+	}
+	delete(tenants, quietest)
+}
+
+// tenantRejectionProbability returns the probability that a request of the
+// tenant will be rejected, given the rejection probability of its priority.
+//
+// The requests admitted for the priority are shared between tenants with a
+// max-min fair allocation of their recent requests: tenants sending fewer
+// requests than the fair share are not throttled, and the remaining capacity
+// is split evenly between the others. The fair share is computed at most once
+// per bucket, as it sorts the demands of every tenant.
+func (t *AdaptiveThrottle) tenantRejectionProbability(
+	p Priority, tenant string, probability float64, now time.Time,
+) float64 {
+	if probability == 0 {
+		return 0
+	}
+
+	t.m.Lock()
+	defer t.m.Unlock()
+
+	if !t.tenantFairness {
+		return probability
+	}
+
+	// Count the current request, so a new tenant does not bypass the throttle
+	own := 1.0
+	if c, ok := t.tenants[int(p)][tenant]; ok {
+		own += float64(c.get(now))
+	}
+	cached := &t.tenantShares[int(p)]
+	if elapsed := now.Sub(cached.at); cached.at.IsZero() || elapsed < 0 || elapsed >= t.d/windowBuckets {
+		*cached = tenantShare{at: now, share: t.tenantFairShare(p, probability, now)}
+	}
+	if own <= cached.share {
+		return 0
+	}
+
+	return clamp(0, 1-cached.share/own, 1)
+}
+
+// tenantShare is the fair share of the tenants of a priority, and the time at
+// which it was computed.
+type tenantShare struct {
+	at    time.Time
+	share float64
+}
+
+// tenantFairShare returns the fair share of the requests admitted for the
+// priority between its tenants, given its rejection probability. t.m must be
+// held.
+func (t *AdaptiveThrottle) tenantFairShare(p Priority, probability float64, now time.Time) float64 {
+	demands := t.tenantDemands[:0]
+	var total float64
+	for name, c := range t.tenants[int(p)] {
+		n := float64(c.get(now))
+		if n == 0 {
+			// The tenant has not been seen within the window
+			delete(t.tenants[int(p)], name)
+
+			continue
+		}
+		demands = append(demands, fairDemand{demand: n, weight: 1})
+		total += n
+	}
+	t.tenantDemands = demands
+
+	return fairShare(demands, (1-probability)*total)
+}
+
+// fairDemand is a demand given to fairShare, with its weight.
+type fairDemand struct {
+	demand float64
+	weight float64
+}
+
+// fairShare returns the weighted max-min fair share of the capacity between
+// the given demands: a demand is served up to its weight times the returned
+// level, every demand under its share is fully served, and the capacity left
+// is split between the others in proportion to their weight. It returns +Inf
+// when the capacity serves every demand. The demands are sorted in place.
+func fairShare(demands []fairDemand, capacity float64) float64 {
+	var total float64
+	for _, d := range demands {
+		total += d.weight
+	}
+	slices.SortFunc(demands, func(a, b fairDemand) int {
+		return cmp.Compare(a.demand/a.weight, b.demand/b.weight)
+	})
+
+	for _, d := range demands {
+		if d.demand/d.weight*total > capacity {
+			return capacity / total
+		}
+		capacity -= d.demand
+		total -= d.weight
+	}
+
+	return math.Inf(1)
+}
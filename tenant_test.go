@@ -0,0 +1,64 @@
+package bulwark_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/deixis/bulwark"
+	"github.com/deixis/faults"
+)
+
+func TestTenantFairness(t *testing.T) {
+	now := time.Now()
+	newThrottle := func(options ...bulwark.AdaptiveThrottleOption) *bulwark.AdaptiveThrottle {
+		throttle := bulwark.NewAdaptiveThrottle(bulwark.StandardPriorities, append(options,
+			bulwark.WithAdaptiveThrottleClock(func() time.Time { return now }),
+			bulwark.WithAdaptiveThrottleRandom(func() float64 { return 0.5 }),
+		)...)
+
+		// The noisy tenant sends 9 times more requests than the quiet one, and
+		// they all fail.
+		send := func(tenant string, n int) {
+			ctx := bulwark.WithTenant(context.Background(), tenant)
+			for i := 0; i < n; i++ {
+				_ = throttle.Throttle(ctx, bulwark.High, func(ctx context.Context) error {
+					return faults.Unavailable(0)
+				})
+			}
+		}
+		send("noisy", 90)
+		send("quiet", 10)
+		// The fair share of the tenants is computed at most once per bucket
+		now = now.Add(6 * time.Second)
+
+		return throttle
+	}
+	admitted := func(throttle *bulwark.AdaptiveThrottle, tenant string) bool {
+		ctx := bulwark.WithTenant(context.Background(), tenant)
+		err := throttle.Throttle(ctx, bulwark.High, func(ctx context.Context) error {
+			return nil
+		})
+
+		return !errors.Is(err, bulwark.ClientSideRejectionError)
+	}
+
+	throttle := newThrottle()
+	if admitted(throttle, "quiet") || admitted(throttle, "noisy") {
+		t.Error("expected every tenant to be rejected without fairness")
+	}
+
+	throttle = newThrottle(bulwark.WithAdaptiveThrottleTenantFairness(true))
+	if !admitted(throttle, "quiet") {
+		t.Error("expected the quiet tenant to be admitted")
+	}
+	if admitted(throttle, "noisy") {
+		t.Error("expected the noisy tenant to be rejected")
+	}
+
+	throttle.Reconfigure(bulwark.WithAdaptiveThrottleTenantFairness(false))
+	if admitted(throttle, "quiet") {
+		t.Error("expected the quiet tenant to be rejected once fairness is disabled")
+	}
+}